@@ -0,0 +1,137 @@
+package main
+
+import "time"
+
+// Buffered gives the stage an input buffer, so the previous stage
+// can run ahead by up to size items instead of lock-step handoff.
+// Pipelines set it for every stage with PipelineStage.Buffer.
+func Buffered(pipe job, size int) job {
+	if size < 1 {
+		return pipe
+	}
+	return func(in, out chan interface{}) {
+		buf := make(chan interface{}, size)
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			defer close(buf)
+			for {
+				select {
+				case val, ok := <-in:
+					if !ok {
+						return
+					}
+					select {
+					case buf <- val:
+					case <-done:
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+		pipe(buf, out)
+	}
+}
+
+// Batch groups items into []interface{} of up to size items. A batch that
+// is not full is flushed timeout after its first item, 0 means wait until full.
+func Batch(size int, timeout time.Duration) job {
+	return BatchWithClock(nil, size, timeout)
+}
+
+// BatchWithClock is Batch measuring the timeout with clock.
+func BatchWithClock(clock Clock, size int, timeout time.Duration) job {
+	clock = clockOrReal(clock)
+	if size < 0 {
+		size = 0
+	}
+	return func(in, out chan interface{}) {
+		batch := make([]interface{}, 0, size)
+		var deadline <-chan time.Time
+		flush := func() {
+			if len(batch) > 0 {
+				out <- batch
+				batch = make([]interface{}, 0, size)
+			}
+			deadline = nil
+		}
+		for {
+			select {
+			case val, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				if len(batch) == 0 && timeout > 0 {
+					deadline = clock.After(timeout)
+				}
+				batch = append(batch, val)
+				if size > 0 && len(batch) >= size {
+					flush()
+				}
+			case <-deadline:
+				flush()
+			}
+		}
+	}
+}
+
+// Unbatch flattens batches back to single items.
+func Unbatch(in, out chan interface{}) {
+	for val := range in {
+		batch, ok := val.([]interface{})
+		if !ok {
+			out <- val
+			continue
+		}
+		for _, item := range batch {
+			out <- item
+		}
+	}
+}
+
+// Batched makes a stage that processes items in bulk: items are grouped by Batch,
+// every batch is passed to process and its results are emitted one by one.
+// If process panics, items taken by the stage but not processed are dropped.
+func Batched(size int, timeout time.Duration, process func([]interface{}) []interface{}) job {
+	return func(in, out chan interface{}) {
+		done := make(chan struct{})
+		input := make(chan interface{})
+		go func() {
+			defer close(input)
+			for {
+				select {
+				case val, ok := <-in:
+					if !ok {
+						return
+					}
+					select {
+					case input <- val:
+					case <-done:
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+		batches := make(chan interface{})
+		go func() {
+			defer close(batches)
+			Batch(size, timeout)(input, batches)
+		}()
+		// stops the batch goroutine when process panics
+		defer func() {
+			close(done)
+			for range batches {
+			}
+		}()
+		for val := range batches {
+			for _, res := range process(val.([]interface{})) {
+				out <- res
+			}
+		}
+	}
+}
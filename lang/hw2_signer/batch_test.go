@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runAhead runs a producer of 10 items into a blocked consumer with an input
+// buffer of 3 and returns how many items were sent when the consumer took
+// the first one.
+func runAhead(t *testing.T, execute func(producer, consumer job)) uint32 {
+	var sent uint32
+	sends := make(chan struct{}, 10)
+	release := make(chan struct{})
+	var first uint32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		execute(func(in, out chan interface{}) {
+			for i := 0; i < 10; i++ {
+				out <- i
+				atomic.AddUint32(&sent, 1)
+				sends <- struct{}{}
+			}
+		}, func(in, out chan interface{}) {
+			<-release
+			<-in
+			first = atomic.LoadUint32(&sent)
+			for range in {
+			}
+		})
+	}()
+	// the producer runs ahead of the blocked stage
+	for i := 0; i < 3; i++ {
+		select {
+		case <-sends:
+		case <-time.After(time.Second):
+			t.Fatalf("producer should run ahead of a blocked buffered stage")
+		}
+	}
+	close(release)
+	<-done
+	return first
+}
+
+func TestBuffered(t *testing.T) {
+	first := runAhead(t, func(producer, consumer job) {
+		ExecutePipeline(producer, Buffered(consumer, 3))
+	})
+	// but not more than the buffer and the handoffs of the pipeline allow
+	if first == 10 {
+		t.Errorf("producer should wait for a blocked buffered stage")
+	}
+}
+
+func TestStageBuffer(t *testing.T) {
+	first := runAhead(t, func(producer, consumer job) {
+		ExecuteStages(DefaultSupervisor, PipelineStage{Job: producer}, PipelineStage{Job: consumer, Buffer: 3})
+	})
+	if first == 10 {
+		t.Errorf("producer should wait for a blocked buffered stage")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	var batches [][]interface{}
+	ExecutePipeline(
+		numbers(7),
+		Batch(3, 0),
+		func(in, out chan interface{}) {
+			for val := range in {
+				batches = append(batches, val.([]interface{}))
+			}
+		},
+	)
+	if fmt.Sprint(batches) != "[[0 1 2] [3 4 5] [6]]" {
+		t.Errorf("wrong batches: %v", batches)
+	}
+
+	in := make(chan interface{})
+	out := make(chan interface{})
+	go Batch(10, 20*time.Millisecond)(in, out)
+	in <- 1
+	in <- 2
+	select {
+	case batch := <-out:
+		if len(batch.([]interface{})) != 2 {
+			t.Errorf("wrong batch flushed by timeout: %v", batch)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("batch not flushed by timeout")
+	}
+	close(in)
+}
+
+func TestBatched(t *testing.T) {
+	var sizes []int
+	var sum int
+	ExecutePipeline(
+		numbers(10),
+		Batched(4, 0, func(batch []interface{}) []interface{} {
+			sizes = append(sizes, len(batch))
+			res := make([]interface{}, len(batch))
+			for i, val := range batch {
+				res[i] = val.(int) * 2
+			}
+			return res
+		}),
+		func(in, out chan interface{}) {
+			for val := range in {
+				sum += val.(int)
+			}
+		},
+	)
+	if fmt.Sprint(sizes) != "[4 4 2]" || sum != 90 {
+		t.Errorf("wrong batches %v or sum %v", sizes, sum)
+	}
+}
+
+func TestBatchedPanic(t *testing.T) {
+	in, out := make(chan interface{}), make(chan interface{})
+	stage := Batched(2, 0, func(batch []interface{}) []interface{} {
+		panic("process")
+	})
+	go func() {
+		in <- 1
+		in <- 2
+	}()
+	if err := runProtected(stage, in, out); err == nil {
+		t.Fatalf("panic of process should fail the stage")
+	}
+	select {
+	case in <- 3:
+		t.Errorf("batch goroutine should stop with the stage")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// signBatch signs a whole batch with one pipeline run.
+func signBatch(batch []interface{}) []interface{} {
+	res := make([]interface{}, 0, len(batch))
+	ExecutePipeline(
+		func(in, out chan interface{}) {
+			for _, val := range batch {
+				out <- val
+			}
+		},
+		SingleHash,
+		MultiHash,
+		func(in, out chan interface{}) {
+			for val := range in {
+				res = append(res, val)
+			}
+		},
+	)
+	return res
+}
+
+func BenchmarkSignerChain(b *testing.B) {
+	var md5Calls, crc32Calls uint32
+	withFastSigners(b, &md5Calls, &crc32Calls)
+
+	chains := []struct {
+		name   string
+		stages []job
+	}{
+		{"unbuffered", []job{SingleHash, MultiHash}},
+		{"buffered16", []job{Buffered(SingleHash, 16), Buffered(MultiHash, 16)}},
+		{"batched10", []job{Batched(10, time.Millisecond, signBatch)}},
+	}
+	for _, chain := range chains {
+		b.Run(chain.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				jobs := append([]job{numbers(50)}, chain.stages...)
+				jobs = append(jobs, CombineResults, func(in, out chan interface{}) {
+					<-in
+				})
+				ExecutePipeline(jobs...)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"container/list"
+	"runtime/debug"
+	"sync"
+)
+
+// Optional memoization of signer results, nil means disabled.
+// Caches are keyed by input data only, call Reset after changing DataSignerSalt.
+var (
+	Md5Cache   *SignCache
+	Crc32Cache *SignCache
+)
+
+// SignCache is a bounded LRU cache of signatures. Concurrent requests for
+// the same key wait for the single in-flight call instead of signing again.
+// Failed calls are not cached, a panic of the call is returned to all of
+// the requests as *PanicError.
+type SignCache struct {
+	mu       sync.Mutex
+	size     int
+	order    *list.List
+	items    map[string]*list.Element
+	inflight map[string]*cacheCall
+	stats    CacheStats
+}
+
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+	Len    int
+}
+
+type cacheEntry struct {
+	key string
+	val string
+}
+
+type cacheCall struct {
+	done chan struct{}
+	val  string
+	err  error
+}
+
+func NewSignCache(size int) *SignCache {
+	if size < 1 {
+		size = 1
+	}
+	return &SignCache{
+		size:     size,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+		inflight: make(map[string]*cacheCall),
+	}
+}
+
+func (c *SignCache) Get(key string, sign func(string) (string, error)) (val string, err error) {
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		c.order.MoveToFront(el)
+		c.stats.Hits++
+		c.mu.Unlock()
+		return el.Value.(*cacheEntry).val, nil
+	}
+	if call, ok := c.inflight[key]; ok {
+		c.stats.Hits++
+		c.mu.Unlock()
+		<-call.done
+		return call.val, call.err
+	}
+	call := &cacheCall{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			call.err = &PanicError{r, debug.Stack()}
+			val, err = "", call.err
+		}
+		c.mu.Lock()
+		delete(c.inflight, key)
+		c.mu.Unlock()
+		close(call.done)
+	}()
+	call.val, call.err = sign(key)
+	if call.err == nil {
+		c.add(key, call.val)
+	}
+	return call.val, call.err
+}
+
+func (c *SignCache) add(key, val string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*cacheEntry).val = val
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&cacheEntry{key, val})
+	for c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.items, last.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *SignCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.items = make(map[string]*list.Element)
+	c.stats = CacheStats{}
+}
+
+func (c *SignCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Len = c.order.Len()
+	return stats
+}
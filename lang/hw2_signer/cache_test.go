@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withFastSigners replaces signer functions with counting versions
+// without artificial delays and restores the originals after the test.
+func withFastSigners(t testing.TB, md5Calls, crc32Calls *uint32) {
+	origMd5, origCrc32 := DataSignerMd5, DataSignerCrc32
+	DataSignerMd5 = func(data string) string {
+		atomic.AddUint32(md5Calls, 1)
+		data += DataSignerSalt
+		return fmt.Sprintf("%x", md5.Sum([]byte(data)))
+	}
+	DataSignerCrc32 = func(data string) string {
+		atomic.AddUint32(crc32Calls, 1)
+		data += DataSignerSalt
+		time.Sleep(time.Millisecond)
+		return strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(data))), 10)
+	}
+	t.Cleanup(func() {
+		DataSignerMd5, DataSignerCrc32 = origMd5, origCrc32
+	})
+}
+
+func TestSignCacheEviction(t *testing.T) {
+	c := NewSignCache(2)
+	var calls int
+	sign := func(data string) (string, error) {
+		calls++
+		return "signed " + data, nil
+	}
+	c.Get("a", sign)
+	c.Get("b", sign)
+	c.Get("a", sign)
+	c.Get("c", sign)
+	if res, err := c.Get("a", sign); err != nil || res != "signed a" {
+		t.Errorf("wrong cached value: %v", res)
+	}
+	c.Get("b", sign)
+	if calls != 4 {
+		t.Errorf("least recently used key should be evicted, calls: %v", calls)
+	}
+	stats := c.Stats()
+	if stats.Hits != 2 || stats.Misses != 4 || stats.Len != 2 {
+		t.Errorf("wrong cache stats: %+v", stats)
+	}
+}
+
+func TestSignCacheSingleFlight(t *testing.T) {
+	c := NewSignCache(10)
+	var calls uint32
+	release := make(chan struct{})
+	sign := func(data string) (string, error) {
+		atomic.AddUint32(&calls, 1)
+		<-release
+		return data, nil
+	}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Get("key", sign)
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("concurrent identical requests should be signed once: %v", calls)
+	}
+}
+
+func TestSignCachePanic(t *testing.T) {
+	c := NewSignCache(10)
+	release := make(chan struct{})
+	panicking := func(data string) (string, error) {
+		<-release
+		panic("sign failed")
+	}
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := c.Get("key", panicking)
+			errs <- err
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	for i := 0; i < 3; i++ {
+		var panicErr *PanicError
+		if err := <-errs; !errors.As(err, &panicErr) || !strings.Contains(string(panicErr.Stack), "TestSignCachePanic") {
+			t.Fatalf("panic should reach every request with its stack: %v", err)
+		}
+	}
+	if res, err := c.Get("key", func(data string) (string, error) {
+		return "signed", nil
+	}); err != nil || res != "signed" {
+		t.Errorf("failed call should not be cached: %v, %v", res, err)
+	}
+}
+
+func TestSignerWithCache(t *testing.T) {
+	var md5Calls, crc32Calls uint32
+	withFastSigners(t, &md5Calls, &crc32Calls)
+	Md5Cache, Crc32Cache = NewSignCache(100), NewSignCache(100)
+	defer func() {
+		Md5Cache, Crc32Cache = nil, nil
+	}()
+
+	inputData := []int{1, 1, 1, 2, 2}
+	var result string
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			for _, val := range inputData {
+				out <- val
+			}
+		}),
+		job(SingleHash),
+		job(MultiHash),
+		job(CombineResults),
+		job(func(in, out chan interface{}) {
+			result = (<-in).(string)
+		}),
+	)
+
+	// 2 unique values: 1 md5, 2 crc32 in SingleHash, 6 crc32 in MultiHash each
+	if md5Calls != 2 || crc32Calls != 16 {
+		t.Errorf("duplicates should be signed once, md5: %v, crc32: %v", md5Calls, crc32Calls)
+	}
+	if Crc32Cache.Stats().Hits != 24 {
+		t.Errorf("wrong hits count: %+v", Crc32Cache.Stats())
+	}
+	expected := "2212294583~709660146"
+	if got := <-runSingleHash(1); got != expected {
+		t.Errorf("cached signature differs\nGot: %v\nExpected: %v", got, expected)
+	}
+	if result == "" {
+		t.Errorf("empty combined result")
+	}
+}
+
+func runSingleHash(val interface{}) chan interface{} {
+	in := make(chan interface{}, 1)
+	out := make(chan interface{}, 1)
+	in <- val
+	close(in)
+	SingleHash(in, out)
+	return out
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Checkpoint is an append-only file of signed items keyed by their
+// sequence number in the input stream.
+type Checkpoint struct {
+	mu      sync.Mutex
+	file    *os.File
+	records map[int]checkpointRecord
+}
+
+type checkpointRecord struct {
+	Seq    int    `json:"seq"`
+	Input  string `json:"input"`
+	Result string `json:"result"`
+}
+
+// OpenCheckpoint loads stored results and opens the file for appending.
+// A torn record left by a crash at the end of the file is cut off.
+func OpenCheckpoint(path string) (*Checkpoint, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{file: file, records: make(map[int]checkpointRecord)}
+
+	var offset int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		rec := checkpointRecord{}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("checkpoint %s: broken record at offset %d: %v", path, offset, err)
+		}
+		cp.records[rec.Seq] = rec
+		offset += int64(len(line))
+	}
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return cp, nil
+}
+
+// Done returns the stored result if the item with this number and input was signed.
+func (cp *Checkpoint) Done(seq int, input string) (string, bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	rec, ok := cp.records[seq]
+	if !ok || rec.Input != input {
+		return "", false
+	}
+	return rec.Result, true
+}
+
+func (cp *Checkpoint) Save(seq int, input, result string) error {
+	rec := checkpointRecord{seq, input, result}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if _, err := cp.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := cp.file.Sync(); err != nil {
+		return err
+	}
+	cp.records[seq] = rec
+	return nil
+}
+
+func (cp *Checkpoint) Len() int {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return len(cp.records)
+}
+
+func (cp *Checkpoint) Close() error {
+	return cp.file.Close()
+}
+
+// Checkpointed makes a stage that signs every item through chain separately,
+// so results can be matched with inputs, and stores them in cp.
+// Items already stored are emitted from the checkpoint without signing.
+func Checkpointed(cp *Checkpoint, chain ...job) job {
+	return func(in, out chan interface{}) {
+		wg := &sync.WaitGroup{}
+		seq := 0
+		for val := range in {
+			if passItemError(val, out) {
+				continue
+			}
+			input := fmt.Sprintf("%v", val)
+			if res, ok := cp.Done(seq, input); ok {
+				out <- res
+				seq++
+				continue
+			}
+			wg.Add(1)
+			go func(seq int, input string) {
+				defer wg.Done()
+				res, err := signValue(input, chain)
+				if err == nil {
+					err = cp.Save(seq, input, res)
+				}
+				if err != nil {
+					out <- &ItemError{input, err}
+					return
+				}
+				out <- res
+			}(seq, input)
+			seq++
+		}
+		wg.Wait()
+	}
+}
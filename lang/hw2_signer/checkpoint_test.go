@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func runCheckpointed(t *testing.T, path string, values []int) (interface{}, error) {
+	t.Helper()
+	cp, err := OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	var result interface{}
+	err = ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			for _, val := range values {
+				out <- val
+			}
+		}),
+		Checkpointed(cp, SingleHash, MultiHash),
+		job(CombineResults),
+		job(func(in, out chan interface{}) {
+			result = <-in
+		}),
+	)
+	return result, err
+}
+
+func TestCheckpointResume(t *testing.T) {
+	var md5Calls, crc32Calls uint32
+	withFastSigners(t, &md5Calls, &crc32Calls)
+	values := []int{0, 1, 1, 2, 3, 5, 8}
+	expected, err := runCheckpointed(t, filepath.Join(t.TempDir(), "full.log"), values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "signer.log")
+	fastMd5 := DataSignerMd5
+	DataSignerMd5 = func(data string) string {
+		if data == "5" {
+			panic("crash in the middle")
+		}
+		return fastMd5(data)
+	}
+	if _, err := runCheckpointed(t, path, values); err == nil {
+		t.Fatalf("expected the first run to crash")
+	}
+	DataSignerMd5 = fastMd5
+
+	cp, err := OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := cp.Len()
+	cp.Close()
+	if saved == 0 || saved == len(values) {
+		t.Fatalf("first run should save part of the results: %v", saved)
+	}
+
+	md5Calls, crc32Calls = 0, 0
+	result, err := runCheckpointed(t, path, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+	if int(md5Calls) != len(values)-saved {
+		t.Errorf("saved items should not be signed again, md5 calls: %v", md5Calls)
+	}
+}
+
+func TestCheckpointTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signer.log")
+	cp, err := OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.Save(0, "0", "first")
+	cp.Close()
+
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"seq":1,"inp`)
+	f.Close()
+
+	cp, err = OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.Save(1, "1", "second")
+	cp.Close()
+
+	cp, err = OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	if res, ok := cp.Done(1, "1"); !ok || res != "second" {
+		t.Errorf("record after torn one not loaded: %v", res)
+	}
+	if _, ok := cp.Done(0, "changed input"); ok {
+		t.Errorf("changed input should be signed again")
+	}
+}
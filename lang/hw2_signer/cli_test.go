@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runCLI(t *testing.T, stdin string, args ...string) string {
+	t.Helper()
+	salt := DataSignerSalt
+	defer func() {
+		DataSignerSalt = salt
+	}()
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := run(args, strings.NewReader(stdin), stdout, stderr); err != nil {
+		t.Fatalf("run failed: %v\n%v", err, stderr)
+	}
+	return stdout.String()
+}
+
+func TestCLIItems(t *testing.T) {
+	var md5Calls, crc32Calls uint32
+	withFastSigners(t, &md5Calls, &crc32Calls)
+
+	out := runCLI(t, "0\n\n1\n", "-concurrency", "1")
+	expected := "0\t29568666068035183841425683795340791879727309630931025356555\n" +
+		"1\t4958044192186797981418233587017209679042592862002427381542\n"
+	if out != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", out, expected)
+	}
+
+	out = runCLI(t, "1\n", "-algo", "single", "-format", "json")
+	var items []signedItem
+	if err := json.Unmarshal([]byte(out), &items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Input != "1" || items[0].Signature != "2212294583~709660146" {
+		t.Errorf("wrong json items: %v", out)
+	}
+}
+
+func TestCLICombineFiles(t *testing.T) {
+	var md5Calls, crc32Calls uint32
+	withFastSigners(t, &md5Calls, &crc32Calls)
+
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first.txt"), filepath.Join(dir, "second.txt")
+	if err := os.WriteFile(first, []byte("0\n1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(second, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := runCLI(t, "", "-combine", first, second)
+	expected := "29568666068035183841425683795340791879727309630931025356555_" +
+		"4958044192186797981418233587017209679042592862002427381542_" +
+		"4958044192186797981418233587017209679042592862002427381542\n"
+	if out != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", out, expected)
+	}
+
+	salted := runCLI(t, "", "-combine", "-salt", "secret", "-format", "json", first, second)
+	if strings.Contains(salted, strings.TrimSpace(out)) || !strings.HasPrefix(salted, `{"combined":`) {
+		t.Errorf("salt not applied: %v", salted)
+	}
+}
+
+func TestCLICombineFailed(t *testing.T) {
+	var md5Calls, crc32Calls uint32
+	withFastSigners(t, &md5Calls, &crc32Calls)
+	crc32Sign := DataSignerCrc32
+	DataSignerCrc32 = func(data string) string {
+		if data == "bad" {
+			panic("bad value")
+		}
+		return crc32Sign(data)
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	err := run([]string{"-combine"}, strings.NewReader("0\nbad\n1\n"), stdout, stderr)
+	if err == nil || !strings.HasSuffix(err.Error(), "failed to sign: 1") {
+		t.Errorf("failed values should fail the run: %v", err)
+	}
+	if !strings.HasPrefix(stderr.String(), "bad\terror: ") {
+		t.Errorf("failed value should be reported: %q", stderr)
+	}
+	expected := "29568666068035183841425683795340791879727309630931025356555_" +
+		"4958044192186797981418233587017209679042592862002427381542\n"
+	if stdout.String() != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", stdout, expected)
+	}
+}
+
+func TestCLIStreaming(t *testing.T) {
+	var md5Calls, crc32Calls uint32
+	withFastSigners(t, &md5Calls, &crc32Calls)
+
+	stdin, input := io.Pipe()
+	output, stdout := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- run([]string{"-algo", "single"}, stdin, stdout, &bytes.Buffer{})
+		stdout.Close()
+	}()
+	lines := bufio.NewScanner(output)
+	for _, val := range []string{"0", "1"} {
+		if _, err := io.WriteString(input, val+"\n"); err != nil {
+			t.Fatal(err)
+		}
+		// the result is written while stdin is still open
+		if !lines.Scan() || !strings.HasPrefix(lines.Text(), val+"\t") {
+			t.Fatalf("no result for %v: %q", val, lines.Text())
+		}
+	}
+	input.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if lines.Scan() {
+		t.Errorf("unexpected output: %q", lines.Text())
+	}
+}
+
+func TestCLIErrors(t *testing.T) {
+	stderr := &bytes.Buffer{}
+	if err := run([]string{"-algo", "sha1"}, strings.NewReader(""), &bytes.Buffer{}, stderr); err == nil {
+		t.Errorf("expected error for unknown algo")
+	}
+	if err := run([]string{"-concurrency", "0"}, strings.NewReader(""), &bytes.Buffer{}, stderr); err == nil {
+		t.Errorf("expected error for zero concurrency")
+	}
+	if err := run([]string{"missing.txt"}, strings.NewReader(""), &bytes.Buffer{}, stderr); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
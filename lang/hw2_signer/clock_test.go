@@ -0,0 +1,166 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FakeClock is a virtual time source, sleepers wake up only when time is advanced.
+type FakeClock struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	now      time.Time
+	sleepers []*sleeper
+	// registered is signalled whenever a sleeper is added.
+	registered chan struct{}
+}
+
+type sleeper struct {
+	until time.Time
+	ch    chan time.Time
+}
+
+func NewFakeClock() *FakeClock {
+	c := &FakeClock{
+		now:        time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+		registered: make(chan struct{}, 1),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.sleepers = append(c.sleepers, &sleeper{c.now.Add(d), ch})
+	c.cond.Broadcast()
+	select {
+	case c.registered <- struct{}{}:
+	default:
+	}
+	return ch
+}
+
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.advanceTo(c.now.Add(d))
+}
+
+// AdvanceToNext jumps to the earliest sleeper deadline, returns false if nobody sleeps.
+func (c *FakeClock) AdvanceToNext() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.sleepers) == 0 {
+		return false
+	}
+	next := c.sleepers[0].until
+	for _, s := range c.sleepers {
+		if s.until.Before(next) {
+			next = s.until
+		}
+	}
+	c.advanceTo(next)
+	return true
+}
+
+func (c *FakeClock) advanceTo(t time.Time) {
+	sort.SliceStable(c.sleepers, func(i, j int) bool {
+		return c.sleepers[i].until.Before(c.sleepers[j].until)
+	})
+	for len(c.sleepers) > 0 && !c.sleepers[0].until.After(t) {
+		s := c.sleepers[0]
+		c.sleepers = c.sleepers[1:]
+		c.now = s.until
+		s.ch <- s.until
+	}
+	c.now = t
+}
+
+// BlockUntil waits until n sleepers are registered.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.sleepers) < n {
+		c.cond.Wait()
+	}
+}
+
+// runVirtual runs f with a fake clock and returns elapsed virtual time.
+// f must run in a single goroutine and wait for every timer it sets,
+// so nothing happens until the time is advanced to its deadline.
+func runVirtual(f func(clock Clock)) time.Duration {
+	clock := NewFakeClock()
+	start := clock.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f(clock)
+	}()
+	for {
+		select {
+		case <-done:
+			return clock.Now().Sub(start)
+		case <-clock.registered:
+			clock.AdvanceToNext()
+		}
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	clock := NewFakeClock()
+	start := clock.Now()
+	woken := make(chan time.Duration, 2)
+	for _, d := range []time.Duration{time.Second, time.Minute} {
+		go func(d time.Duration) {
+			clock.Sleep(d)
+			woken <- clock.Now().Sub(start)
+		}(d)
+	}
+	clock.BlockUntil(2)
+
+	clock.Advance(30 * time.Second)
+	if got := <-woken; got < time.Second {
+		t.Errorf("sleeper woke up too early: %s", got)
+	}
+	select {
+	case <-woken:
+		t.Errorf("second sleeper should still sleep")
+	default:
+	}
+	if !clock.AdvanceToNext() || <-woken != time.Minute {
+		t.Errorf("second sleeper should wake up at its deadline")
+	}
+	if clock.AdvanceToNext() {
+		t.Errorf("no sleepers left")
+	}
+}
+
+func TestThrottleVirtualTime(t *testing.T) {
+	elapsed := runVirtual(func(clock Clock) {
+		th := NewThrottleWithClock(clock, 0, 10, 1)
+		for i := 0; i < 11; i++ {
+			th.Acquire()
+		}
+	})
+	if elapsed != time.Second {
+		t.Errorf("10 qps throttle should take exactly 1s for 11 calls: %s", elapsed)
+	}
+}
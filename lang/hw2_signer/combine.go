@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Window describes when CombineWindowed emits a partial result.
+// The zero value is the global window: everything is combined once the input is closed.
+type Window struct {
+	// Size flushes a window after that many items, 0 means unbounded.
+	Size int
+	// Period flushes a window that long after its first item, 0 means no limit.
+	Period time.Duration
+	// Key splits the stream into independent windows, results are emitted as KeyedResult.
+	Key func(interface{}) string
+	// Clock measures Period, nil means real time.
+	Clock Clock
+}
+
+func GlobalWindow() Window {
+	return Window{}
+}
+
+func CountWindow(size int) Window {
+	return Window{Size: size}
+}
+
+func TimeWindow(period time.Duration) Window {
+	return Window{Period: period}
+}
+
+func KeyWindow(key func(interface{}) string) Window {
+	return Window{Key: key}
+}
+
+type KeyedResult struct {
+	Key    string
+	Result string
+}
+
+func (r KeyedResult) String() string {
+	return r.Key + ":" + r.Result
+}
+
+type windowGroup struct {
+	key      string
+	values   []string
+	deadline time.Time
+}
+
+func CombineWindowed(w Window) job {
+	return func(in, out chan interface{}) {
+		combineWindowed(in, out, w)
+	}
+}
+
+func combineWindowed(in, out chan interface{}, w Window) {
+	clock := clockOrReal(w.Clock)
+	groups := make(map[string]*windowGroup)
+	var keys []string
+	emitted := false
+
+	flush := func(g *windowGroup) {
+		delete(groups, g.key)
+		for i, key := range keys {
+			if key == g.key {
+				keys = append(keys[:i], keys[i+1:]...)
+				break
+			}
+		}
+		sort.Strings(g.values)
+		result := strings.Join(g.values, "_")
+		if w.Key != nil {
+			out <- KeyedResult{g.key, result}
+		} else {
+			out <- result
+		}
+		emitted = true
+	}
+
+	var timerC <-chan time.Time
+	resetTimer := func() {
+		timerC = nil
+		var earliest time.Time
+		for _, g := range groups {
+			if earliest.IsZero() || g.deadline.Before(earliest) {
+				earliest = g.deadline
+			}
+		}
+		if w.Period > 0 && !earliest.IsZero() {
+			timerC = clock.After(earliest.Sub(clock.Now()))
+		}
+	}
+
+	for {
+		select {
+		case val, ok := <-in:
+			if !ok {
+				for len(keys) > 0 {
+					flush(groups[keys[0]])
+				}
+				if !emitted && w.Size == 0 && w.Period == 0 && w.Key == nil {
+					out <- ""
+				}
+				return
+			}
+			if passItemError(val, out) {
+				continue
+			}
+			key := ""
+			if w.Key != nil {
+				key = w.Key(val)
+			}
+			changed := false
+			g, exists := groups[key]
+			// the timer may not have been handled yet, a late item starts a new window
+			if exists && w.Period > 0 && !g.deadline.After(clock.Now()) {
+				flush(g)
+				exists = false
+			}
+			if !exists {
+				g = &windowGroup{key: key, deadline: clock.Now().Add(w.Period)}
+				groups[key] = g
+				keys = append(keys, key)
+				changed = true
+			}
+			g.values = append(g.values, fmt.Sprintf("%v", val))
+			if w.Size > 0 && len(g.values) >= w.Size {
+				flush(g)
+				changed = true
+			}
+			if changed {
+				resetTimer()
+			}
+		case now := <-timerC:
+			for _, key := range append([]string(nil), keys...) {
+				if g := groups[key]; !g.deadline.After(now) {
+					flush(g)
+				}
+			}
+			resetTimer()
+		}
+	}
+}
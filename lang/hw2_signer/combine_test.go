@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func runCombine(w Window, feed func(in chan interface{})) []interface{} {
+	in := make(chan interface{})
+	out := make(chan interface{}, 100)
+	go func() {
+		feed(in)
+		close(in)
+	}()
+	combineWindowed(in, out, w)
+	close(out)
+	results := make([]interface{}, 0)
+	for val := range out {
+		results = append(results, val)
+	}
+	return results
+}
+
+func checkResults(t *testing.T, got []interface{}, expected ...string) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Fatalf("wrong results count\nGot: %v\nExpected: %v", got, expected)
+	}
+	for i := range expected {
+		if fmt.Sprint(got[i]) != expected[i] {
+			t.Errorf("results not match\nGot: %v\nExpected: %v", got, expected)
+			return
+		}
+	}
+}
+
+func TestCombineGlobalWindow(t *testing.T) {
+	checkResults(t, runCombine(GlobalWindow(), func(in chan interface{}) {
+		for _, val := range []string{"c", "a", "b"} {
+			in <- val
+		}
+	}), "a_b_c")
+	checkResults(t, runCombine(GlobalWindow(), func(in chan interface{}) {}), "")
+}
+
+func TestCombineCountWindow(t *testing.T) {
+	checkResults(t, runCombine(CountWindow(2), func(in chan interface{}) {
+		for _, val := range []int{3, 1, 5, 4, 2} {
+			in <- val
+		}
+	}), "1_3", "4_5", "2")
+}
+
+func TestCombineTimeWindow(t *testing.T) {
+	clock := NewFakeClock()
+	w := TimeWindow(30 * time.Millisecond)
+	w.Clock = clock
+	checkResults(t, runCombine(w, func(in chan interface{}) {
+		in <- 2
+		in <- 1
+		clock.BlockUntil(1)
+		clock.Advance(30 * time.Millisecond)
+		in <- 3
+	}), "1_2", "3")
+}
+
+func TestCombineKeyWindow(t *testing.T) {
+	w := KeyWindow(func(val interface{}) string {
+		if val.(int)%2 == 0 {
+			return "even"
+		}
+		return "odd"
+	})
+	w.Size = 2
+	checkResults(t, runCombine(w, func(in chan interface{}) {
+		for _, val := range []int{1, 2, 4, 3, 5, 6} {
+			in <- val
+		}
+	}), "even:2_4", "odd:1_3", "odd:5", "even:6")
+}
+
+func TestCombineWindowedStreaming(t *testing.T) {
+	in := make(chan interface{})
+	out := make(chan interface{})
+	go combineWindowed(in, out, CountWindow(2))
+	in <- 1
+	in <- 2
+	select {
+	case res := <-out:
+		if res != "1_2" {
+			t.Errorf("wrong partial result: %v", res)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("partial result not emitted before input closed")
+	}
+	close(in)
+}
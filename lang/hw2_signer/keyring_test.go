@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestVerifierRotation(t *testing.T) {
+	var md5Calls, crc32Calls uint32
+	withFastSigners(t, &md5Calls, &crc32Calls)
+
+	keys := NewKeyring()
+	v := NewVerifier(keys)
+	if _, err := v.Sign("1"); err != ErrNoActiveKey {
+		t.Errorf("expected no active key error, got %v", err)
+	}
+	if err := keys.Rotate("2017-01", "first salt"); err != nil {
+		t.Fatal(err)
+	}
+	oldSig, err := v.Sign("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(oldSig, "2017-01:") {
+		t.Errorf("key id not embedded: %v", oldSig)
+	}
+
+	if err := keys.Rotate("2017-02", "second salt"); err != nil {
+		t.Fatal(err)
+	}
+	newSig, _ := v.Sign("1")
+	if !strings.HasPrefix(newSig, "2017-02:") || newSig[8:] == oldSig[8:] {
+		t.Errorf("new signature should use the new key: %v", newSig)
+	}
+	for _, sig := range []string{oldSig, newSig} {
+		if err := v.Verify("1", sig); err != nil {
+			t.Errorf("signature %v should verify: %v", sig, err)
+		}
+	}
+
+	if err := v.Verify("2", newSig); err != ErrBadSignature {
+		t.Errorf("signature of another value should not verify: %v", err)
+	}
+	if err := v.Verify("1", newSig+"0"); err != ErrBadSignature {
+		t.Errorf("tampered signature should not verify: %v", err)
+	}
+	if err := v.Verify("1", "2016-12:"+oldSig[8:]); err != ErrUnknownKey {
+		t.Errorf("signature with unknown key should not verify: %v", err)
+	}
+	if err := v.Verify("1", "no key id"); err != ErrMalformedSignature {
+		t.Errorf("expected malformed signature error, got %v", err)
+	}
+
+	if err := keys.Remove("2017-02"); err == nil {
+		t.Errorf("active key should not be removed")
+	}
+	keys.Remove("2017-01")
+	if err := v.Verify("1", oldSig); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("retired key should not verify: %v", err)
+	}
+}
+
+func TestVerifierStage(t *testing.T) {
+	var md5Calls, crc32Calls uint32
+	withFastSigners(t, &md5Calls, &crc32Calls)
+
+	keys := NewKeyring()
+	keys.Rotate("k1", "salt")
+	v := NewVerifier(keys, SingleHash)
+	var sigs []string
+	err := ExecutePipeline(numbers(3), v.Stage(), func(in, out chan interface{}) {
+		for sig := range in {
+			sigs = append(sigs, sig.(string))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(sigs)
+	if len(sigs) != 3 {
+		t.Fatalf("wrong signatures: %v", sigs)
+	}
+	for _, sig := range sigs {
+		ok := false
+		for _, value := range []string{"0", "1", "2"} {
+			ok = ok || v.Verify(value, sig) == nil
+		}
+		if !ok {
+			t.Errorf("signature %v does not match any value", sig)
+		}
+	}
+}
+
+func TestVerifierSaltBoundary(t *testing.T) {
+	var md5Calls, crc32Calls uint32
+	withFastSigners(t, &md5Calls, &crc32Calls)
+
+	keys := NewKeyring()
+	keys.Add("k1", "23")
+	keys.Add("k2", "3")
+	keys.SetActive("k1")
+	v := NewVerifier(keys, SingleHash)
+	sig, err := v.Sign("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "1" with salt "23" should not pass for "12" with salt "3"
+	if err := v.Verify("12", "k2"+strings.TrimPrefix(sig, "k1")); err != ErrBadSignature {
+		t.Errorf("signature should not move between keys: %v", err)
+	}
+}
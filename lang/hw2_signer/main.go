@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+type signedItem struct {
+	Input     string `json:"input"`
+	Signature string `json:"signature,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+var chains = map[string][]job{
+	"full":   {SingleHash, MultiHash},
+	"single": {SingleHash},
+	"multi":  {MultiHash},
+}
+
+// signValue runs a single value through the chain, so the result
+// can be matched with its input.
+func signValue(val string, chain []job) (string, error) {
+	var res interface{}
+	jobs := []job{func(in, out chan interface{}) {
+		out <- val
+	}}
+	jobs = append(jobs, chain...)
+	jobs = append(jobs, func(in, out chan interface{}) {
+		for v := range in {
+			res = v
+		}
+	})
+	if err := ExecutePipeline(jobs...); err != nil {
+		return "", err
+	}
+	if res == nil {
+		return "", errors.New("no signature produced")
+	}
+	return fmt.Sprintf("%v", res), nil
+}
+
+// defaultConcurrency bounds values signed at once, signing mostly waits
+// for DataSigner functions so it is not tied to the number of CPUs.
+const defaultConcurrency = 16
+
+// readValues is the first stage of the CLI pipeline, it sends non-empty lines
+// of the readers as they are read. Files are opened one at a time, the first
+// error stops reading and is stored in err.
+func readValues(stdin io.Reader, paths []string, err *error) job {
+	return func(in, out chan interface{}) {
+		if len(paths) == 0 {
+			*err = sendLines(stdin, out)
+			return
+		}
+		for _, path := range paths {
+			f, openErr := os.Open(path)
+			if openErr != nil {
+				*err = openErr
+				return
+			}
+			*err = sendLines(f, out)
+			f.Close()
+			if *err != nil {
+				return
+			}
+		}
+	}
+}
+
+func sendLines(r io.Reader, out chan interface{}) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			out <- line
+		}
+	}
+	return scanner.Err()
+}
+
+// signValues signs values by concurrency workers, sending a signedItem
+// for each value as soon as it is done.
+func signValues(chain []job, concurrency int) job {
+	return func(in, out chan interface{}) {
+		wg := &sync.WaitGroup{}
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for val := range in {
+					item := signedItem{Input: fmt.Sprint(val)}
+					sig, err := signValue(item.Input, chain)
+					if err != nil {
+						item.Error = err.Error()
+					} else {
+						item.Signature = sig
+					}
+					out <- item
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// signatures passes signatures of signed items to CombineResults,
+// failed items are left out, written to w and counted in failed.
+func signatures(w io.Writer, failed *int) job {
+	return func(in, out chan interface{}) {
+		for val := range in {
+			item := val.(signedItem)
+			if item.Error != "" {
+				fmt.Fprintf(w, "%s\terror: %s\n", item.Input, item.Error)
+				*failed++
+				continue
+			}
+			out <- item.Signature
+		}
+	}
+}
+
+// writeItems is the last stage of the CLI pipeline, it writes items as they
+// arrive, a JSON array is written element by element. The first write error
+// is stored in err, the rest of the items is drained.
+func writeItems(w io.Writer, format string, err *error) job {
+	return func(in, out chan interface{}) {
+		write := func(f string, args ...interface{}) {
+			if *err == nil {
+				_, *err = fmt.Fprintf(w, f, args...)
+			}
+		}
+		sep := "["
+		for val := range in {
+			item := val.(signedItem)
+			switch {
+			case format == "json":
+				data, jsonErr := json.Marshal(item)
+				if jsonErr != nil && *err == nil {
+					*err = jsonErr
+				}
+				write("%s%s", sep, data)
+				sep = ",\n"
+			case item.Error != "":
+				write("%s\terror: %s\n", item.Input, item.Error)
+			default:
+				write("%s\t%s\n", item.Input, item.Signature)
+			}
+		}
+		if format == "json" {
+			if sep == "[" {
+				write("[")
+			}
+			write("]\n")
+		}
+	}
+}
+
+// writeCombined writes the combined result of CombineResults.
+func writeCombined(w io.Writer, format string, err *error) job {
+	return func(in, out chan interface{}) {
+		for val := range in {
+			if format == "json" {
+				*err = json.NewEncoder(w).Encode(map[string]string{"combined": val.(string)})
+			} else {
+				_, *err = fmt.Fprintln(w, val)
+			}
+		}
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("signer", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: signer [flags] [file ...]\nreads newline-delimited values from files or stdin")
+		flags.PrintDefaults()
+	}
+	salt := flags.String("salt", DataSignerSalt, "salt appended to data by DataSigner functions")
+	concurrency := flags.Int("concurrency", defaultConcurrency, "values signed in parallel")
+	algo := flags.String("algo", "full", "signing chain: full (SingleHash+MultiHash), single or multi")
+	combine := flags.Bool("combine", false, "print the combined result instead of per-item signatures")
+	format := flags.String("format", "text", "output format: text or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	chain, ok := chains[*algo]
+	if !ok {
+		return fmt.Errorf("unknown algo %q", *algo)
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if *concurrency < 1 {
+		return fmt.Errorf("concurrency should be positive, got %d", *concurrency)
+	}
+
+	DataSignerSalt = *salt
+	var readErr, writeErr error
+	var failed int
+	jobs := []job{readValues(stdin, flags.Args(), &readErr), signValues(chain, *concurrency)}
+	if *combine {
+		jobs = append(jobs, signatures(stderr, &failed), CombineResults, writeCombined(stdout, *format, &writeErr))
+	} else {
+		jobs = append(jobs, writeItems(stdout, *format, &writeErr))
+	}
+	if err := ExecutePipeline(jobs...); err != nil {
+		return err
+	}
+	if readErr != nil {
+		return readErr
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	if failed > 0 {
+		return fmt.Errorf("combined result leaves out values failed to sign: %d", failed)
+	}
+	return nil
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+}
+
+// PipelineMetrics collects per-stage statistics of an instrumented pipeline.
+// Latency is measured from the moment a stage takes an item to the moment
+// it emits it: an output equal to an item in flight is matched with that item,
+// so stages passing items through may reorder them. Other outputs are matched
+// with the oldest item in flight, which is exact for stages keeping the order.
+type PipelineMetrics struct {
+	// Trace, if set, is called synchronously on every item passing a stage boundary.
+	Trace func(StageEvent)
+
+	mu     sync.Mutex
+	stages []*stageMetrics
+	start  time.Time
+	finish time.Time
+}
+
+// goroutineSampleInterval is how often goroutines of the stages are counted.
+const goroutineSampleInterval = 10 * time.Millisecond
+
+// stageLabel is the profiler label of goroutines run by a stage.
+const stageLabel = "pipeline_stage"
+
+type StageEvent struct {
+	Stage   int
+	Name    string
+	Kind    string // "in" or "out"
+	Blocked time.Duration
+	Latency time.Duration
+}
+
+type stageMetrics struct {
+	index          int
+	name           string
+	itemsIn        int64
+	itemsOut       int64
+	maxInFlight    int64
+	receiveBlocked time.Duration
+	sendBlocked    time.Duration
+	accepted       []*acceptedItem
+	latency        histogram
+	// label tells goroutines of the stage in goroutine profiles.
+	label          string
+	goroutines     int
+	peakGoroutines int
+}
+
+// acceptedItem is queued before the handoff to the stage, so an output
+// emitted right after the handoff always finds its input in the queue.
+type acceptedItem struct {
+	val interface{}
+	at  time.Time
+}
+
+type histogram struct {
+	counts []int64
+	count  int64
+	sum    time.Duration
+}
+
+func (h *histogram) observe(d time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]int64, len(LatencyBuckets)+1)
+	}
+	i := 0
+	for i < len(LatencyBuckets) && d > LatencyBuckets[i] {
+		i++
+	}
+	h.counts[i]++
+	h.count++
+	h.sum += d
+}
+
+type HistogramSnapshot struct {
+	Bounds []time.Duration
+	// Counts has one more element than Bounds, the last one is +Inf.
+	Counts []int64
+	Count  int64
+	Sum    time.Duration
+}
+
+func (h HistogramSnapshot) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+type StageSnapshot struct {
+	Index          int
+	Name           string
+	ItemsIn        int64
+	ItemsOut       int64
+	InFlight       int64
+	MaxInFlight    int64
+	ReceiveBlocked time.Duration
+	SendBlocked    time.Duration
+	Latency        HistogramSnapshot
+	// Goroutines run by the stage at the last sample, and the most seen.
+	Goroutines     int
+	PeakGoroutines int
+}
+
+type PipelineSnapshot struct {
+	Stages  []StageSnapshot
+	Elapsed time.Duration
+}
+
+func NewPipelineMetrics() *PipelineMetrics {
+	return &PipelineMetrics{}
+}
+
+// ExecuteInstrumentedPipeline runs jobs like ExecuteSupervisedPipeline,
+// recording metrics of every stage in m.
+func ExecuteInstrumentedPipeline(m *PipelineMetrics, s Supervisor, jobs ...job) error {
+	m.mu.Lock()
+	m.stages = make([]*stageMetrics, len(jobs))
+	for i, j := range jobs {
+		m.stages[i] = &stageMetrics{index: i, name: jobName(j), label: fmt.Sprintf("%p-%d", m, i)}
+	}
+	m.start = time.Now()
+	m.finish = time.Time{}
+	m.mu.Unlock()
+
+	stages := make([]PipelineStage, len(jobs))
+	for i, j := range jobs {
+		stages[i] = PipelineStage{Job: m.instrument(m.stages[i], j), Name: m.stages[i].name}
+	}
+	stop := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(goroutineSampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.sampleGoroutines()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	err := ExecuteStages(s, stages...)
+	close(stop)
+	<-sampled
+
+	m.mu.Lock()
+	m.finish = time.Now()
+	m.mu.Unlock()
+	return err
+}
+
+func jobName(j job) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(j).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+	name := fn.Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+func (m *PipelineMetrics) instrument(s *stageMetrics, pipe job) job {
+	return func(in, out chan interface{}) {
+		stageIn := make(chan interface{})
+		stageOut := make(chan interface{})
+		stageDone := make(chan struct{})
+		forwarded := make(chan struct{})
+
+		go func() {
+			defer close(stageIn)
+			for {
+				start := time.Now()
+				var val interface{}
+				var ok bool
+				select {
+				case val, ok = <-in:
+				case <-stageDone:
+					return
+				}
+				if !ok {
+					return
+				}
+				item := m.accept(s, val, time.Since(start))
+				select {
+				case stageIn <- val:
+				case <-stageDone:
+					return
+				}
+				m.handedOff(item)
+			}
+		}()
+
+		// stageOut is never closed, goroutines left by a panicked stage
+		// block on it instead of sending on a closed channel
+		go func() {
+			defer close(forwarded)
+			for {
+				select {
+				case val := <-stageOut:
+					m.emit(s, val)
+					start := time.Now()
+					out <- val
+					m.sent(s, time.Since(start))
+				case <-stageDone:
+					return
+				}
+			}
+		}()
+
+		defer func() {
+			close(stageDone)
+			<-forwarded
+		}()
+		// goroutines started by the stage inherit the label
+		pprof.Do(context.Background(), pprof.Labels(stageLabel, s.label), func(context.Context) {
+			pipe(stageIn, stageOut)
+		})
+	}
+}
+
+func (m *PipelineMetrics) accept(s *stageMetrics, val interface{}, blocked time.Duration) *acceptedItem {
+	m.mu.Lock()
+	item := &acceptedItem{val, time.Now()}
+	s.itemsIn++
+	s.receiveBlocked += blocked
+	s.accepted = append(s.accepted, item)
+	if inFlight := s.itemsIn - s.itemsOut; inFlight > s.maxInFlight {
+		s.maxInFlight = inFlight
+	}
+	event := StageEvent{Stage: s.index, Name: s.name, Kind: "in", Blocked: blocked}
+	m.mu.Unlock()
+	m.trace(event)
+	return item
+}
+
+func (m *PipelineMetrics) handedOff(item *acceptedItem) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item.at = time.Now()
+}
+
+func (m *PipelineMetrics) emit(s *stageMetrics, val interface{}) {
+	m.mu.Lock()
+	s.itemsOut++
+	var latency time.Duration
+	if len(s.accepted) > 0 {
+		i := 0
+		for j, item := range s.accepted {
+			if sameItem(item.val, val) {
+				i = j
+				break
+			}
+		}
+		latency = time.Since(s.accepted[i].at)
+		s.accepted = append(s.accepted[:i], s.accepted[i+1:]...)
+		s.latency.observe(latency)
+	}
+	event := StageEvent{Stage: s.index, Name: s.name, Kind: "out", Latency: latency}
+	m.mu.Unlock()
+	m.trace(event)
+}
+
+func (m *PipelineMetrics) sent(s *stageMetrics, blocked time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.sendBlocked += blocked
+}
+
+// sameItem compares items, values of uncomparable types are never the same.
+func sameItem(a, b interface{}) (same bool) {
+	defer func() {
+		if recover() != nil {
+			same = false
+		}
+	}()
+	return a == b
+}
+
+func (m *PipelineMetrics) sampleGoroutines() {
+	counts := labelledGoroutines(stageLabel)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.stages {
+		s.goroutines = counts[s.label]
+		if s.goroutines > s.peakGoroutines {
+			s.peakGoroutines = s.goroutines
+		}
+	}
+}
+
+// labelledGoroutines counts goroutines by the value of their profiler label.
+func labelledGoroutines(label string) map[string]int {
+	b := &bytes.Buffer{}
+	pprof.Lookup("goroutine").WriteTo(b, 1)
+	counts := make(map[string]int)
+	prefix := strconv.Quote(label) + ":"
+	n := 0
+	for _, line := range strings.Split(b.String(), "\n") {
+		if i := strings.Index(line, " @ "); i > 0 && !strings.HasPrefix(line, "#") {
+			n, _ = strconv.Atoi(line[:i])
+			continue
+		}
+		if !strings.HasPrefix(line, "# labels: ") {
+			continue
+		}
+		i := strings.Index(line, prefix)
+		if i < 0 {
+			continue
+		}
+		if value, err := strconv.QuotedPrefix(line[i+len(prefix):]); err == nil {
+			value, _ = strconv.Unquote(value)
+			counts[value] += n
+		}
+	}
+	return counts
+}
+
+func (m *PipelineMetrics) trace(e StageEvent) {
+	if m.Trace != nil {
+		m.Trace(e)
+	}
+}
+
+func (m *PipelineMetrics) Snapshot() PipelineSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snap := PipelineSnapshot{}
+	if !m.start.IsZero() {
+		if m.finish.IsZero() {
+			snap.Elapsed = time.Since(m.start)
+		} else {
+			snap.Elapsed = m.finish.Sub(m.start)
+		}
+	}
+	for _, s := range m.stages {
+		inFlight := s.itemsIn - s.itemsOut
+		if inFlight < 0 {
+			inFlight = 0
+		}
+		counts := make([]int64, len(LatencyBuckets)+1)
+		copy(counts, s.latency.counts)
+		snap.Stages = append(snap.Stages, StageSnapshot{
+			Index:          s.index,
+			Name:           s.name,
+			ItemsIn:        s.itemsIn,
+			ItemsOut:       s.itemsOut,
+			InFlight:       inFlight,
+			MaxInFlight:    s.maxInFlight,
+			ReceiveBlocked: s.receiveBlocked,
+			SendBlocked:    s.sendBlocked,
+			Latency: HistogramSnapshot{
+				Bounds: append([]time.Duration(nil), LatencyBuckets...),
+				Counts: counts,
+				Count:  s.latency.count,
+				Sum:    s.latency.sum,
+			},
+			Goroutines:     s.goroutines,
+			PeakGoroutines: s.peakGoroutines,
+		})
+	}
+	return snap
+}
+
+func (m *PipelineMetrics) WritePrometheus(w io.Writer) error {
+	return m.Snapshot().WritePrometheus(w)
+}
+
+func (s PipelineSnapshot) WritePrometheus(w io.Writer) error {
+	b := &strings.Builder{}
+	metric := func(name, kind, help string, value func(st StageSnapshot) string) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, st := range s.Stages {
+			fmt.Fprintf(b, "%s{%s} %s\n", name, st.labels(), value(st))
+		}
+	}
+	metric("pipeline_stage_items_in_total", "counter", "Items received by the stage.",
+		func(st StageSnapshot) string { return fmt.Sprint(st.ItemsIn) })
+	metric("pipeline_stage_items_out_total", "counter", "Items emitted by the stage.",
+		func(st StageSnapshot) string { return fmt.Sprint(st.ItemsOut) })
+	metric("pipeline_stage_in_flight", "gauge", "Items taken by the stage and not emitted yet.",
+		func(st StageSnapshot) string { return fmt.Sprint(st.InFlight) })
+	metric("pipeline_stage_max_in_flight", "gauge", "Peak number of items processed concurrently.",
+		func(st StageSnapshot) string { return fmt.Sprint(st.MaxInFlight) })
+	metric("pipeline_stage_receive_blocked_seconds_total", "counter", "Time the stage waited for input.",
+		func(st StageSnapshot) string { return seconds(st.ReceiveBlocked) })
+	metric("pipeline_stage_send_blocked_seconds_total", "counter", "Time the stage output was blocked by the next stage.",
+		func(st StageSnapshot) string { return seconds(st.SendBlocked) })
+	metric("pipeline_stage_goroutines", "gauge", "Goroutines run by the stage at the last sample.",
+		func(st StageSnapshot) string { return fmt.Sprint(st.Goroutines) })
+	metric("pipeline_stage_goroutines_peak", "gauge", "Peak number of goroutines run by the stage.",
+		func(st StageSnapshot) string { return fmt.Sprint(st.PeakGoroutines) })
+
+	name := "pipeline_stage_latency_seconds"
+	fmt.Fprintf(b, "# HELP %s Item processing latency.\n# TYPE %s histogram\n", name, name)
+	for _, st := range s.Stages {
+		var cumulative int64
+		for i, bound := range st.Latency.Bounds {
+			cumulative += st.Latency.Counts[i]
+			fmt.Fprintf(b, "%s_bucket{%s,le=\"%s\"} %d\n", name, st.labels(), seconds(bound), cumulative)
+		}
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, st.labels(), st.Latency.Count)
+		fmt.Fprintf(b, "%s_sum{%s} %s\n", name, st.labels(), seconds(st.Latency.Sum))
+		fmt.Fprintf(b, "%s_count{%s} %d\n", name, st.labels(), st.Latency.Count)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (st StageSnapshot) labels() string {
+	return fmt.Sprintf("stage=\"%d\",name=%q", st.Index, st.Name)
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprint(d.Seconds())
+}
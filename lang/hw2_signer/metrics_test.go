@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInstrumentedPipeline(t *testing.T) {
+	var md5Calls, crc32Calls uint32
+	withFastSigners(t, &md5Calls, &crc32Calls)
+
+	var events uint32
+	m := NewPipelineMetrics()
+	m.Trace = func(e StageEvent) {
+		atomic.AddUint32(&events, 1)
+	}
+	var result interface{}
+	ExecuteInstrumentedPipeline(m, DefaultSupervisor,
+		job(func(in, out chan interface{}) {
+			for i := 0; i < 5; i++ {
+				out <- i
+			}
+		}),
+		job(SingleHash),
+		job(func(in, out chan interface{}) {
+			for val := range in {
+				time.Sleep(20 * time.Millisecond)
+				out <- val
+			}
+		}),
+		job(CombineResults),
+		job(func(in, out chan interface{}) {
+			result = <-in
+		}),
+	)
+	if result == nil {
+		t.Fatalf("pipeline produced no result")
+	}
+
+	snap := m.Snapshot()
+	if len(snap.Stages) != 5 {
+		t.Fatalf("wrong stages count: %v", len(snap.Stages))
+	}
+	if snap.Stages[1].Name != "SingleHash" || snap.Stages[3].Name != "CombineResults" {
+		t.Errorf("wrong stage names: %v, %v", snap.Stages[1].Name, snap.Stages[3].Name)
+	}
+	for _, i := range []int{1, 2} {
+		st := snap.Stages[i]
+		if st.ItemsIn != 5 || st.ItemsOut != 5 || st.Latency.Count != 5 {
+			t.Errorf("wrong items count for stage %v: %+v", st.Name, st)
+		}
+	}
+	if snap.Stages[0].ItemsOut != 5 || snap.Stages[3].ItemsIn != 5 || snap.Stages[3].ItemsOut != 1 {
+		t.Errorf("wrong items count at pipeline edges")
+	}
+	if snap.Stages[2].Latency.Mean() < 20*time.Millisecond {
+		t.Errorf("slow stage latency not recorded: %s", snap.Stages[2].Latency.Mean())
+	}
+	if snap.Stages[1].SendBlocked == 0 {
+		t.Errorf("SingleHash should be blocked by the slow stage")
+	}
+	if snap.Elapsed < 100*time.Millisecond {
+		t.Errorf("wrong pipeline elapsed time: %s", snap.Elapsed)
+	}
+	if snap.Stages[2].PeakGoroutines != 1 {
+		t.Errorf("goroutines should be counted per stage: %v", snap.Stages[2].PeakGoroutines)
+	}
+	// generator out, SingleHash and slow stage in/out, combine in/out, collector in
+	if events != 5+5+5+5+5+5+1+1 {
+		t.Errorf("wrong trace events count: %v", events)
+	}
+
+	b := &strings.Builder{}
+	if err := m.WritePrometheus(b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# TYPE pipeline_stage_latency_seconds histogram",
+		`pipeline_stage_items_in_total{stage="1",name="SingleHash"} 5`,
+		`pipeline_stage_latency_seconds_bucket{stage="2",name="TestInstrumentedPipeline.func3",le="+Inf"} 5`,
+		`pipeline_stage_items_out_total{stage="3",name="CombineResults"} 1`,
+		`pipeline_stage_goroutines_peak{stage="2",name="TestInstrumentedPipeline.func3"} 1`,
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("prometheus output has no line %q:\n%v", line, b.String())
+		}
+	}
+}
+
+func TestInstrumentedReordering(t *testing.T) {
+	m := NewPipelineMetrics()
+	mu := &sync.Mutex{}
+	var latencies []time.Duration
+	m.Trace = func(e StageEvent) {
+		if e.Stage == 1 && e.Kind == "out" {
+			mu.Lock()
+			latencies = append(latencies, e.Latency)
+			mu.Unlock()
+		}
+	}
+	release := make(chan struct{})
+	var order []interface{}
+	ExecuteInstrumentedPipeline(m, DefaultSupervisor,
+		numbers(5),
+		// the first item is held back and comes out last
+		job(func(in, out chan interface{}) {
+			wg := &sync.WaitGroup{}
+			for val := range in {
+				wg.Add(1)
+				go func(val interface{}) {
+					defer wg.Done()
+					if val == 0 {
+						<-release
+					}
+					out <- val
+				}(val)
+			}
+			wg.Wait()
+		}),
+		job(func(in, out chan interface{}) {
+			for val := range in {
+				order = append(order, val)
+				if len(order) != 4 {
+					continue
+				}
+				// let the sampler see the goroutine holding the first item
+				for i := 0; i < 100 && m.Snapshot().Stages[1].Goroutines < 2; i++ {
+					time.Sleep(goroutineSampleInterval)
+				}
+				close(release)
+			}
+		}),
+	)
+
+	if len(order) != 5 || order[4] != 0 {
+		t.Errorf("held item should come out last: %v", order)
+	}
+	stage := m.Snapshot().Stages[1]
+	if stage.PeakGoroutines < 2 {
+		t.Errorf("goroutines started by the stage should be counted: %v", stage.PeakGoroutines)
+	}
+	if stage.ItemsIn != 5 || stage.ItemsOut != 5 || stage.InFlight != 0 || stage.Latency.Count != 5 {
+		t.Fatalf("wrong items count: %+v", stage)
+	}
+	// the held item was taken first and emitted last, so only when matched
+	// with its own input it has the longest latency
+	held := latencies[len(latencies)-1]
+	for _, latency := range latencies[:len(latencies)-1] {
+		if latency > held {
+			t.Errorf("items should be matched with their own input: %v", latencies)
+			break
+		}
+	}
+}
+
+func TestInstrumentedPanic(t *testing.T) {
+	m := NewPipelineMetrics()
+	sending := make(chan struct{})
+	release := make(chan struct{})
+	panicking := func(in, out chan interface{}) {
+		for range in {
+		}
+		go func() {
+			<-release
+			close(sending)
+			out <- "late"
+		}()
+		panic("stage")
+	}
+	err := ExecuteInstrumentedPipeline(m, Supervisor{Policy: RestartStage}, numbers(2), panicking)
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) || stageErr.Name != jobName(panicking) {
+		t.Errorf("panic should abort the pipeline with the stage name: %v", err)
+	}
+	// the goroutine left by the stage blocks instead of crashing the process
+	close(release)
+	<-sending
+	runtime.Gosched()
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Frames are [length uint32][type byte][item id uint64][payload],
+// length covers everything after itself.
+const (
+	frameHello byte = iota + 1
+	frameItem
+	frameResult
+	frameError
+)
+
+const (
+	maxFrameLen   = 1 << 20
+	maxPayloadLen = maxFrameLen - 1 - 8
+)
+
+var (
+	ErrFrameTooLarge = errors.New("frame too large")
+	// ErrItemLost fails an item that was in flight every time the connection
+	// was lost, it might be what brings the worker down.
+	ErrItemLost = errors.New("item lost with the connection")
+)
+
+type frame struct {
+	kind    byte
+	id      uint64
+	payload string
+}
+
+func writeFrame(w io.Writer, f frame) error {
+	if len(f.payload) > maxPayloadLen {
+		return fmt.Errorf("%w: %d bytes of payload", ErrFrameTooLarge, len(f.payload))
+	}
+	buf := make([]byte, 4+1+8+len(f.payload))
+	binary.BigEndian.PutUint32(buf, uint32(1+8+len(f.payload)))
+	buf[4] = f.kind
+	binary.BigEndian.PutUint64(buf[5:], f.id)
+	copy(buf[13:], f.payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readFrame(r io.Reader) (frame, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return frame{}, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length < 9 || length > maxFrameLen {
+		return frame{}, fmt.Errorf("bad frame length %d", length)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return frame{}, err
+	}
+	return frame{buf[0], binary.BigEndian.Uint64(buf[1:]), string(buf[9:])}, nil
+}
+
+// maxConnItems bounds items of a connection processed at once, the server
+// stops reading frames of the connection until one of them is done.
+const maxConnItems = 64
+
+// StageServer hosts stages for remote pipelines. Every item is processed
+// separately, so results can be matched with items by the proxy.
+type StageServer struct {
+	mu        sync.Mutex
+	stages    map[string]job
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+func NewStageServer() *StageServer {
+	return &StageServer{
+		stages:    make(map[string]job),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+func (s *StageServer) Register(name string, j job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stages[name] = j
+}
+
+func (s *StageServer) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errors.New("stage server closed")
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			delete(s.listeners, l)
+			if s.closed {
+				return nil
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *StageServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	s.dropConnections()
+	return nil
+}
+
+func (s *StageServer) dropConnections() {
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+}
+
+func (s *StageServer) track(conn net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		if s.closed {
+			return false
+		}
+		s.conns[conn] = struct{}{}
+	} else {
+		delete(s.conns, conn)
+	}
+	return true
+}
+
+func (s *StageServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	if !s.track(conn, true) {
+		return
+	}
+	defer s.track(conn, false)
+
+	reader := bufio.NewReader(conn)
+	hello, err := readFrame(reader)
+	if err != nil || hello.kind != frameHello {
+		return
+	}
+	s.mu.Lock()
+	stage, ok := s.stages[hello.payload]
+	s.mu.Unlock()
+	writeMu := &sync.Mutex{}
+	if !ok {
+		writeFrame(conn, frame{frameError, 0, fmt.Sprintf("unknown stage %q", hello.payload)})
+		return
+	}
+
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	sem := make(chan struct{}, maxConnItems)
+	for {
+		f, err := readFrame(reader)
+		if err != nil || f.kind != frameItem {
+			return
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(f frame) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			res, err := signValue(f.payload, []job{stage})
+			reply := frame{frameResult, f.id, res}
+			if err != nil {
+				reply = frame{frameError, f.id, err.Error()}
+			}
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			if err := writeFrame(conn, reply); errors.Is(err, ErrFrameTooLarge) {
+				writeFrame(conn, frame{frameError, f.id, err.Error()})
+			}
+		}(f)
+	}
+}
+
+type RemoteOptions struct {
+	DialTimeout time.Duration
+	// Reconnect controls dial attempts after the connection is lost.
+	Reconnect RetryPolicy
+	// MaxSends bounds how many times an item is sent, 3 if not set. An item
+	// in flight when the connection is lost that many times fails with
+	// ErrItemLost, so a poison item does not bring the worker down forever.
+	MaxSends int
+	// ItemTimeout bounds waiting for the next result while items are in
+	// flight, 30s if not set. A worker that does not answer in time is
+	// treated as a lost connection, so a hung worker cannot stall the stage.
+	ItemTimeout time.Duration
+}
+
+var DefaultRemoteOptions = RemoteOptions{
+	DialTimeout: 5 * time.Second,
+	ItemTimeout: 30 * time.Second,
+	Reconnect:   RetryPolicy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: 2 * time.Second, Jitter: 0.2},
+}
+
+// RemoteStage makes a stage that sends items to a stage hosted by a StageServer.
+// Items are kept until their result arrives and are sent again after reconnection,
+// so a remote stage must be safe to run more than once for an item.
+// Items too large for a frame fail with ErrFrameTooLarge.
+func RemoteStage(addr, name string, opts RemoteOptions) job {
+	if opts.MaxSends < 1 {
+		opts.MaxSends = 3
+	}
+	if opts.ItemTimeout <= 0 {
+		opts.ItemTimeout = 30 * time.Second
+	}
+	return func(in, out chan interface{}) {
+		p := &remoteProxy{addr: addr, name: name, opts: opts, inflight: make(map[uint64]*remoteItem), nextID: 1}
+		p.run(in, out)
+	}
+}
+
+type remoteItem struct {
+	data  string
+	sends int
+}
+
+type remoteProxy struct {
+	addr string
+	name string
+	opts RemoteOptions
+
+	mu        sync.Mutex
+	conn      net.Conn
+	writeMu   sync.Mutex
+	inflight  map[uint64]*remoteItem
+	nextID    uint64
+	inputDone bool
+	finished  bool
+	failed    error
+}
+
+func (p *remoteProxy) run(in, out chan interface{}) {
+	pumped := make(chan struct{})
+	go func() {
+		defer close(pumped)
+		p.pump(in, out)
+	}()
+
+	for {
+		conn, err := p.dial()
+		if err == nil {
+			var done bool
+			if done, err = p.readResults(conn, out); done {
+				break
+			}
+		}
+		if err != nil {
+			p.failAll(err, out)
+			break
+		}
+	}
+	<-pumped
+}
+
+func (p *remoteProxy) dial() (net.Conn, error) {
+	res, err := p.opts.Reconnect.Do(func() (interface{}, error) {
+		conn, err := net.DialTimeout("tcp", p.addr, p.opts.DialTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFrame(conn, frame{frameHello, 0, p.name}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	conn := res.(net.Conn)
+
+	p.mu.Lock()
+	p.conn = conn
+	p.watchLocked(conn)
+	resend := make([]frame, 0, len(p.inflight))
+	for id, item := range p.inflight {
+		item.sends++
+		resend = append(resend, frame{frameItem, id, item.data})
+	}
+	finished := p.finished
+	p.mu.Unlock()
+	if finished {
+		conn.Close()
+	}
+	for _, f := range resend {
+		p.write(conn, f)
+	}
+	return conn, nil
+}
+
+func (p *remoteProxy) pump(in, out chan interface{}) {
+	for val := range in {
+		if passItemError(val, out) {
+			continue
+		}
+		data := fmt.Sprintf("%v", val)
+		if len(data) > maxPayloadLen {
+			out <- &ItemError{data, fmt.Errorf("%w: %d bytes of payload", ErrFrameTooLarge, len(data))}
+			continue
+		}
+		p.mu.Lock()
+		if p.failed != nil {
+			err := p.failed
+			p.mu.Unlock()
+			out <- &ItemError{data, err}
+			continue
+		}
+		id := p.nextID
+		p.nextID++
+		item := &remoteItem{data: data}
+		p.inflight[id] = item
+		conn := p.conn
+		if conn != nil {
+			item.sends++
+			if len(p.inflight) == 1 {
+				p.watchLocked(conn)
+			}
+		}
+		p.mu.Unlock()
+		if conn != nil {
+			p.write(conn, frame{frameItem, id, data})
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inputDone = true
+	p.finishIfDone()
+}
+
+func (p *remoteProxy) finishIfDone() {
+	if p.inputDone && len(p.inflight) == 0 && !p.finished {
+		p.finished = true
+		if p.conn != nil {
+			p.conn.Close()
+		}
+	}
+}
+
+// write closes a broken connection, so the reader notices it and reconnects.
+func (p *remoteProxy) write(conn net.Conn, f frame) {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	if err := writeFrame(conn, f); err != nil {
+		conn.Close()
+	}
+}
+
+// readResults returns true when all items are done, false if the connection was lost
+// or timed out and an error if the worker refused the stage.
+func (p *remoteProxy) readResults(conn net.Conn, out chan interface{}) (bool, error) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		f, err := readFrame(reader)
+		if err != nil {
+			return p.lost(out), nil
+		}
+		if f.id == 0 && f.kind == frameError {
+			return false, fmt.Errorf("remote stage %q: %s", p.name, f.payload)
+		}
+		p.mu.Lock()
+		item, ok := p.inflight[f.id]
+		delete(p.inflight, f.id)
+		p.mu.Unlock()
+		if ok {
+			if f.kind == frameError {
+				out <- &ItemError{item.data, errors.New(f.payload)}
+			} else {
+				out <- f.payload
+			}
+		}
+		p.mu.Lock()
+		p.finishIfDone()
+		p.watchLocked(conn)
+		p.mu.Unlock()
+	}
+}
+
+// watchLocked sets the read deadline of conn to ItemTimeout from now while
+// items are in flight and clears it otherwise, p.mu must be held.
+func (p *remoteProxy) watchLocked(conn net.Conn) {
+	var deadline time.Time
+	if len(p.inflight) > 0 {
+		deadline = time.Now().Add(p.opts.ItemTimeout)
+	}
+	conn.SetReadDeadline(deadline)
+}
+
+// lost fails in-flight items sent MaxSends times after the connection is lost,
+// it returns true when all items are done.
+func (p *remoteProxy) lost(out chan interface{}) bool {
+	p.mu.Lock()
+	p.conn = nil
+	var failed []*remoteItem
+	if !p.finished {
+		for id, item := range p.inflight {
+			if item.sends >= p.opts.MaxSends {
+				failed = append(failed, item)
+				delete(p.inflight, id)
+			}
+		}
+	}
+	p.mu.Unlock()
+	for _, item := range failed {
+		out <- &ItemError{item.data, &RetryError{item.sends, ErrItemLost}}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finishIfDone()
+	return p.finished
+}
+
+// failAll reports in-flight items as failed when the worker is unreachable,
+// the rest of the input is failed by pump.
+func (p *remoteProxy) failAll(err error, out chan interface{}) {
+	p.mu.Lock()
+	p.failed = err
+	p.conn = nil
+	items := make([]string, 0, len(p.inflight))
+	for id, item := range p.inflight {
+		items = append(items, item.data)
+		delete(p.inflight, id)
+	}
+	p.mu.Unlock()
+	for _, data := range items {
+		out <- &ItemError{data, err}
+	}
+}
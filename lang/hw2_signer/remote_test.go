@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func startStageServer(t *testing.T) (*StageServer, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewStageServer()
+	go server.Serve(l)
+	t.Cleanup(func() {
+		server.Close()
+	})
+	return server, l.Addr().String()
+}
+
+var testRemoteOptions = RemoteOptions{
+	DialTimeout: time.Second,
+	Reconnect:   RetryPolicy{MaxAttempts: 3, BaseDelay: 5 * time.Millisecond},
+}
+
+func TestRemoteStage(t *testing.T) {
+	var md5Calls, crc32Calls uint32
+	withFastSigners(t, &md5Calls, &crc32Calls)
+	server, addr := startStageServer(t)
+	server.Register("MultiHash", MultiHash)
+
+	var local, remote interface{}
+	ExecutePipeline(numbers(7), SingleHash, MultiHash, CombineResults, func(in, out chan interface{}) {
+		local = <-in
+	})
+	err := ExecutePipeline(numbers(7), SingleHash, RemoteStage(addr, "MultiHash", testRemoteOptions), CombineResults,
+		func(in, out chan interface{}) {
+			remote = <-in
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local != remote {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", remote, local)
+	}
+}
+
+func TestRemoteStageReconnect(t *testing.T) {
+	server, addr := startStageServer(t)
+	var calls uint32
+	server.Register("slow", func(in, out chan interface{}) {
+		for val := range in {
+			atomic.AddUint32(&calls, 1)
+			time.Sleep(50 * time.Millisecond)
+			out <- val.(string) + "!"
+		}
+	})
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		server.mu.Lock()
+		server.dropConnections()
+		server.mu.Unlock()
+	}()
+	var results []string
+	err := ExecutePipeline(numbers(5), RemoteStage(addr, "slow", testRemoteOptions), func(in, out chan interface{}) {
+		for val := range in {
+			results = append(results, val.(string))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 5 {
+		t.Errorf("every item should be delivered once: %v", results)
+	}
+	if calls <= 5 {
+		t.Errorf("in-flight items should be sent again after reconnection, calls: %v", calls)
+	}
+}
+
+func TestRemoteStageErrors(t *testing.T) {
+	_, addr := startStageServer(t)
+	err := ExecutePipeline(numbers(2), RemoteStage(addr, "missing", testRemoteOptions))
+	if err == nil || !strings.Contains(err.Error(), `unknown stage "missing"`) {
+		t.Errorf("expected unknown stage error, got %v", err)
+	}
+
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	deadAddr := l.Addr().String()
+	l.Close()
+	var failed uint32
+	err = ExecuteSupervisedPipeline(Supervisor{
+		Policy: SkipItem,
+		OnError: func(e *StageError) {
+			atomic.AddUint32(&failed, 1)
+		},
+	}, numbers(3), RemoteStage(deadAddr, "slow", testRemoteOptions))
+	if err != nil || failed != 3 {
+		t.Errorf("items should fail when the worker is unreachable: %v, %v", err, failed)
+	}
+}
+
+func TestRemoteStagePoisonItem(t *testing.T) {
+	server, addr := startStageServer(t)
+	var calls uint32
+	server.Register("fragile", func(in, out chan interface{}) {
+		for val := range in {
+			atomic.AddUint32(&calls, 1)
+			if val == "poison" {
+				server.mu.Lock()
+				server.dropConnections()
+				server.mu.Unlock()
+				continue
+			}
+			out <- val.(string) + "!"
+		}
+	})
+
+	deadLetters := make(chan DeadLetter, 1)
+	err := ExecutePipeline(func(in, out chan interface{}) {
+		out <- "poison"
+	}, WithDeadLetters(RemoteStage(addr, "fragile", testRemoteOptions), deadLetters), func(in, out chan interface{}) {
+		for val := range in {
+			t.Errorf("unexpected result: %v", val)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	letter := <-deadLetters
+	if !errors.Is(letter.Err, ErrItemLost) || letter.Attempts != 3 || atomic.LoadUint32(&calls) != 3 {
+		t.Errorf("poison item should fail after 3 sends: %+v, calls %v", letter, atomic.LoadUint32(&calls))
+	}
+}
+
+func TestRemoteStageHungWorker(t *testing.T) {
+	server, addr := startStageServer(t)
+	release := make(chan struct{})
+	t.Cleanup(func() {
+		close(release)
+	})
+	server.Register("hung", func(in, out chan interface{}) {
+		for range in {
+			<-release
+		}
+	})
+
+	opts := testRemoteOptions
+	opts.ItemTimeout = 20 * time.Millisecond
+	deadLetters := make(chan DeadLetter, 1)
+	err := ExecutePipeline(func(in, out chan interface{}) {
+		out <- "item"
+	}, WithDeadLetters(RemoteStage(addr, "hung", opts), deadLetters), func(in, out chan interface{}) {
+		for val := range in {
+			t.Errorf("unexpected result: %v", val)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	letter := <-deadLetters
+	if !errors.Is(letter.Err, ErrItemLost) || letter.Attempts != 3 {
+		t.Errorf("item of a hung worker should fail after 3 sends: %+v", letter)
+	}
+}
+
+func TestRemoteStageFrameLimit(t *testing.T) {
+	if err := writeFrame(io.Discard, frame{frameItem, 1, strings.Repeat("x", maxPayloadLen+1)}); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("oversized frame should not be written: %v", err)
+	}
+
+	server, addr := startStageServer(t)
+	server.Register("inflate", func(in, out chan interface{}) {
+		for val := range in {
+			out <- strings.Repeat(val.(string), maxPayloadLen+1)
+		}
+	})
+	deadLetters := make(chan DeadLetter, 2)
+	err := ExecutePipeline(func(in, out chan interface{}) {
+		out <- "x"
+		out <- strings.Repeat("x", maxPayloadLen+1)
+	}, WithDeadLetters(RemoteStage(addr, "inflate", testRemoteOptions), deadLetters), func(in, out chan interface{}) {
+		for val := range in {
+			t.Errorf("unexpected result of %d bytes", len(val.(string)))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(deadLetters)
+	failed := 0
+	for letter := range deadLetters {
+		if !strings.Contains(letter.Err.Error(), ErrFrameTooLarge.Error()) {
+			t.Errorf("item should fail with oversized frame: %v", letter.Err)
+		}
+		failed++
+	}
+	if failed != 2 {
+		t.Errorf("both items should fail: %v", failed)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Optional retry policy for DataSigner calls made by SingleHash and MultiHash,
+// nil means a failed call fails the item at once.
+var SignerRetry *RetryPolicy
+
+var ErrAttemptTimeout = errors.New("attempt timed out")
+
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Multiplier grows the delay after every attempt, 2 if not set.
+	Multiplier float64
+	// Jitter randomly shortens every delay by up to this fraction (0..1).
+	Jitter float64
+	// AttemptTimeout abandons a slow attempt, its result is ignored.
+	AttemptTimeout time.Duration
+	// Clock measures delays and timeouts, nil means real time.
+	Clock Clock
+}
+
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("gave up after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+type DeadLetter struct {
+	Item     interface{}
+	Attempts int
+	Err      error
+}
+
+// Backoff returns the delay after the given attempt, starting from 1.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 2
+	}
+	d := float64(p.BaseDelay) * math.Pow(mult, float64(attempt-1))
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		d -= d * math.Min(p.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// Do calls attempt until it succeeds or MaxAttempts are used up.
+// Panics in attempt are returned as *PanicError and retried as well.
+func (p RetryPolicy) Do(attempt func() (interface{}, error)) (interface{}, error) {
+	return p.DoContext(func(context.Context) (interface{}, error) {
+		return attempt()
+	})
+}
+
+// DoContext is Do for attempts taking a context, it is cancelled once
+// the attempt times out, so the attempt can stop waiting and free what it holds.
+func (p RetryPolicy) DoContext(attempt func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	attempts := p.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for i := 1; i <= attempts; i++ {
+		var res interface{}
+		if res, err = p.try(attempt); err == nil {
+			return res, nil
+		}
+		if i < attempts {
+			clockOrReal(p.Clock).Sleep(p.Backoff(i))
+		}
+	}
+	return nil, &RetryError{attempts, err}
+}
+
+type attemptResult struct {
+	res interface{}
+	err error
+}
+
+func (p RetryPolicy) try(attempt func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if p.AttemptTimeout <= 0 {
+		return protectCall(context.Background(), attempt)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan attemptResult, 1)
+	go func() {
+		defer cancel()
+		res, err := protectCall(ctx, attempt)
+		done <- attemptResult{res, err}
+	}()
+	select {
+	case r := <-done:
+		return r.res, r.err
+	case <-clockOrReal(p.Clock).After(p.AttemptTimeout):
+		cancel()
+		return nil, ErrAttemptTimeout
+	}
+}
+
+func protectCall(ctx context.Context, f func(ctx context.Context) (interface{}, error)) (res interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{r, debug.Stack()}
+		}
+	}()
+	return f(ctx)
+}
+
+func callSigner(sign func(ctx context.Context, data string) (string, error), data string) (string, error) {
+	attempt := func(ctx context.Context) (interface{}, error) {
+		return sign(ctx, data)
+	}
+	var res interface{}
+	var err error
+	if SignerRetry == nil {
+		res, err = protectCall(context.Background(), attempt)
+	} else {
+		res, err = SignerRetry.DoContext(attempt)
+	}
+	if err != nil {
+		return "", err
+	}
+	return res.(string), nil
+}
+
+// RetryItems makes a stage that processes every item concurrently with retries.
+// Items that exhausted retries are sent as *ItemError, see WithDeadLetters.
+func RetryItems(p RetryPolicy, process func(interface{}) (interface{}, error)) job {
+	return func(in, out chan interface{}) {
+		wg := &sync.WaitGroup{}
+		for val := range in {
+			if passItemError(val, out) {
+				continue
+			}
+			wg.Add(1)
+			go func(val interface{}) {
+				defer wg.Done()
+				res, err := p.Do(func() (interface{}, error) {
+					return process(val)
+				})
+				if err != nil {
+					out <- &ItemError{val, err}
+					return
+				}
+				out <- res
+			}(val)
+		}
+		wg.Wait()
+	}
+}
+
+// WithDeadLetters redirects failed items emitted by the stage as *ItemError
+// to the deadLetters channel instead of the next stage. The stage output is
+// never closed, goroutines left by a panicked stage block on it instead of
+// sending on a closed channel.
+func WithDeadLetters(pipe job, deadLetters chan<- DeadLetter) job {
+	return func(in, out chan interface{}) {
+		stageOut := make(chan interface{})
+		done := make(chan struct{})
+		forwarded := make(chan struct{})
+		go func() {
+			defer close(forwarded)
+			for {
+				var val interface{}
+				select {
+				case val = <-stageOut:
+				case <-done:
+					return
+				}
+				itemErr, ok := val.(*ItemError)
+				if !ok {
+					out <- val
+					continue
+				}
+				letter := DeadLetter{Item: itemErr.Item, Attempts: 1, Err: itemErr.Err}
+				var retryErr *RetryError
+				if errors.As(itemErr.Err, &retryErr) {
+					letter.Attempts = retryErr.Attempts
+				}
+				deadLetters <- letter
+			}
+		}()
+		defer func() {
+			close(done)
+			<-forwarded
+		}()
+		pipe(in, stageOut)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	expected := []time.Duration{10, 20, 40, 50, 50}
+	for i, d := range expected {
+		if got := p.Backoff(i + 1); got != d*time.Millisecond {
+			t.Errorf("wrong backoff for attempt %d: %s", i+1, got)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.Backoff(2); got < 10*time.Millisecond || got > 20*time.Millisecond {
+			t.Fatalf("jittered backoff out of range: %s", got)
+		}
+	}
+}
+
+func TestRetryDo(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	calls := 0
+	res, err := p.Do(func() (interface{}, error) {
+		calls++
+		if calls < 3 {
+			panic("flaky")
+		}
+		return "ok", nil
+	})
+	if err != nil || res != "ok" || calls != 3 {
+		t.Errorf("expected success on 3rd attempt, got %v %v after %d calls", res, err, calls)
+	}
+
+	calls = 0
+	_, err = p.Do(func() (interface{}, error) {
+		calls++
+		return nil, errors.New("down")
+	})
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 3 || calls != 3 {
+		t.Errorf("expected retry error after 3 attempts, got %v", err)
+	}
+
+	p.AttemptTimeout = 10 * time.Millisecond
+	start := time.Now()
+	_, err = p.Do(func() (interface{}, error) {
+		time.Sleep(time.Second)
+		return "late", nil
+	})
+	if !errors.Is(err, ErrAttemptTimeout) || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("slow attempts should time out, got %v", err)
+	}
+}
+
+func TestRetryTimeoutFreesThrottle(t *testing.T) {
+	th := NewThrottle(1, 0, 0)
+	th.Acquire()
+	var signed uint32
+	p := RetryPolicy{MaxAttempts: 2, AttemptTimeout: 10 * time.Millisecond}
+	_, err := p.DoContext(func(ctx context.Context) (interface{}, error) {
+		return throttled(ctx, th, func(data string) string {
+			atomic.AddUint32(&signed, 1)
+			return data
+		}, "data")
+	})
+	if !errors.Is(err, ErrAttemptTimeout) {
+		t.Fatalf("attempts waiting for the throttle should time out, got %v", err)
+	}
+	th.Release()
+
+	acquired := make(chan struct{})
+	go func() {
+		th.Acquire()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("abandoned attempts should not hold the throttle")
+	}
+	th.Release()
+	if atomic.LoadUint32(&signed) != 0 {
+		t.Errorf("abandoned attempts should not sign")
+	}
+}
+
+func TestSignerRetry(t *testing.T) {
+	var md5Calls, crc32Calls uint32
+	withFastSigners(t, &md5Calls, &crc32Calls)
+	fastCrc32 := DataSignerCrc32
+	mu := &sync.Mutex{}
+	failed := make(map[string]bool)
+	DataSignerCrc32 = func(data string) string {
+		mu.Lock()
+		first := !failed[data]
+		failed[data] = true
+		mu.Unlock()
+		if first {
+			panic("crc32 overheat")
+		}
+		return fastCrc32(data)
+	}
+	SignerRetry = &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}
+	defer func() {
+		SignerRetry = nil
+	}()
+
+	expected := "2212294583~709660146"
+	if got := <-runSingleHash(1); got != expected {
+		t.Errorf("flaky calls should be retried\nGot: %v\nExpected: %v", got, expected)
+	}
+}
+
+func TestRetryItemsDeadLetters(t *testing.T) {
+	deadLetters := make(chan DeadLetter, 10)
+	p := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	stage := RetryItems(p, func(val interface{}) (interface{}, error) {
+		if val.(int) == 2 {
+			return nil, errors.New("unsupported value")
+		}
+		return val.(int) * 10, nil
+	})
+
+	sum := 0
+	err := ExecutePipeline(
+		numbers(4),
+		WithDeadLetters(stage, deadLetters),
+		func(in, out chan interface{}) {
+			for val := range in {
+				sum += val.(int)
+			}
+		},
+	)
+	close(deadLetters)
+
+	if err != nil {
+		t.Fatalf("dead letters should not abort the pipeline: %v", err)
+	}
+	if sum != 40 {
+		t.Errorf("wrong sum of processed items: %v", sum)
+	}
+	letters := make([]DeadLetter, 0)
+	for letter := range deadLetters {
+		letters = append(letters, letter)
+	}
+	if len(letters) != 1 || letters[0].Item != 2 || letters[0].Attempts != 3 {
+		t.Errorf("wrong dead letters: %+v", letters)
+	}
+}
+
+func TestDeadLettersPanic(t *testing.T) {
+	sending := make(chan struct{})
+	release := make(chan struct{})
+	panicking := func(in, out chan interface{}) {
+		for range in {
+		}
+		go func() {
+			<-release
+			close(sending)
+			out <- "late"
+		}()
+		panic("stage")
+	}
+	if err := ExecutePipeline(numbers(2), WithDeadLetters(panicking, make(chan DeadLetter))); err == nil {
+		t.Errorf("panic should abort the pipeline")
+	}
+	// the goroutine left by the stage blocks instead of crashing the process
+	close(release)
+	<-sending
+	runtime.Gosched()
+}
@@ -2,45 +2,72 @@ package main
 
 // сюда писать код
 import (
+	"context"
 	"fmt"
-	"sort"
 	"strconv"
-	"strings"
 	"sync"
 )
 
-func ExecutePipeline(jobs ...job) {
-	in := make(chan interface{})
-	wg := &sync.WaitGroup{}
-	for i, pipeJob := range jobs {
-		out := make(chan interface{})
-		wg.Add(1)
-		go func(in, out chan interface{}, pipe job, i int) {
-			defer func() {
-				close(out)
-				wg.Done()
-			}()
-			pipe(in, out)
-		}(in, out, pipeJob, i)
-		in = out
-	}
-	wg.Wait()
+func ExecutePipeline(jobs ...job) error {
+	return ExecuteSupervisedPipeline(DefaultSupervisor, jobs...)
 }
 
 type ordered struct {
 	num  int
 	data string
+	err  error
+}
+
+var (
+	// Md5Throttle is the only limit of DataSignerMd5 calls, its concurrency
+	// should stay 1 as the MD5 signer overheats on parallel calls.
+	Md5Throttle   = NewThrottle(1, 0, 0)
+	Crc32Throttle = NewThrottle(0, 0, 0)
+)
+
+// signMd5 and signCrc32 take a cached signature or sign data once the throttle
+// lets them, an attempt abandoned by ctx stops waiting for the throttle.
+func signMd5(ctx context.Context, data string) (string, error) {
+	sign := func(data string) (string, error) {
+		return throttled(ctx, Md5Throttle, DataSignerMd5, data)
+	}
+	if Md5Cache != nil {
+		return Md5Cache.Get(data, sign)
+	}
+	return sign(data)
+}
+
+func signCrc32(ctx context.Context, data string) (string, error) {
+	sign := func(data string) (string, error) {
+		return throttled(ctx, Crc32Throttle, DataSignerCrc32, data)
+	}
+	if Crc32Cache != nil {
+		return Crc32Cache.Get(data, sign)
+	}
+	return sign(data)
+}
+
+func throttled(ctx context.Context, th *Throttle, sign func(string) string, data string) (string, error) {
+	if _, err := th.AcquireContext(ctx); err != nil {
+		return "", err
+	}
+	defer th.Release()
+	return sign(data), nil
 }
 
 func SingleHash(in chan interface{}, out chan interface{}) {
-	mu := &sync.Mutex{}
 	wg := &sync.WaitGroup{}
+	defer wg.Wait()
 	for val := range in {
+		if passItemError(val, out) {
+			continue
+		}
 		data := fmt.Sprintf("%v", val)
 
 		wg.Add(1)
 		go func(data string) {
 			defer wg.Done()
+			defer recoverItem(data, out)
 
 			inCrc32 := make(chan ordered)
 			outCrc32 := make(chan ordered)
@@ -48,39 +75,47 @@ func SingleHash(in chan interface{}, out chan interface{}) {
 			for i := 0; i < 2; i++ {
 				go func() {
 					crc32Data := <-inCrc32
-					crc32Res := DataSignerCrc32(crc32Data.data)
-					outCrc32 <- ordered{crc32Data.num, crc32Res}
+					if crc32Data.err != nil {
+						outCrc32 <- crc32Data
+						return
+					}
+					crc32Res, err := callSigner(signCrc32, crc32Data.data)
+					outCrc32 <- ordered{crc32Data.num, crc32Res, err}
 				}()
 			}
-			inCrc32 <- ordered{0, data}
+			inCrc32 <- ordered{0, data, nil}
 
 			go func(data string, outMd5 chan<- ordered) {
-				mu.Lock()
-				fromMd5Res := ordered{1, DataSignerMd5(data)}
-				mu.Unlock()
-				outMd5 <- fromMd5Res
+				md5Res, err := callSigner(signMd5, data)
+				outMd5 <- ordered{1, md5Res, err}
 			}(data, inCrc32)
 
 			h1 := <-outCrc32
 			h2 := <-outCrc32
-			if h1.num < h2.num {
+			if h1.err != nil || h2.err != nil {
+				out <- &ItemError{data, firstError(h1.err, h2.err)}
+			} else if h1.num < h2.num {
 				out <- h1.data + "~" + h2.data
 			} else {
 				out <- h2.data + "~" + h1.data
 			}
 		}(data)
 	}
-	wg.Wait()
 }
 
 func MultiHash(in chan interface{}, out chan interface{}) {
 	wg := &sync.WaitGroup{}
+	defer wg.Wait()
 	for val := range in {
+		if passItemError(val, out) {
+			continue
+		}
 		data := fmt.Sprintf("%v", val)
 
 		wg.Add(1)
 		go func(data string) {
 			defer wg.Done()
+			defer recoverItem(data, out)
 
 			inCrc32 := make(chan ordered)
 			outCrc32 := make(chan ordered)
@@ -88,16 +123,22 @@ func MultiHash(in chan interface{}, out chan interface{}) {
 			for i := 0; i <= 5; i++ {
 				go func() {
 					crc32Data := <-inCrc32
-					crc32Res := DataSignerCrc32(strconv.Itoa(crc32Data.num) + crc32Data.data)
-					outCrc32 <- ordered{crc32Data.num, crc32Res}
+					crc32Res, err := callSigner(signCrc32, strconv.Itoa(crc32Data.num)+crc32Data.data)
+					outCrc32 <- ordered{crc32Data.num, crc32Res, err}
 				}()
-				inCrc32 <- ordered{i, data}
+				inCrc32 <- ordered{i, data, nil}
 			}
 
 			result := make(map[int]string)
+			var err error
 			for i := 0; i <= 5; i++ {
 				crc32Res := <-outCrc32
 				result[crc32Res.num] = crc32Res.data
+				err = firstError(err, crc32Res.err)
+			}
+			if err != nil {
+				out <- &ItemError{data, err}
+				return
 			}
 
 			hash := ""
@@ -108,14 +149,8 @@ func MultiHash(in chan interface{}, out chan interface{}) {
 			out <- hash
 		}(data)
 	}
-	wg.Wait()
 }
 
 func CombineResults(in chan interface{}, out chan interface{}) {
-	results := make([]string, 0)
-	for val := range in {
-		results = append(results, fmt.Sprintf("%v", val))
-	}
-	sort.Strings(results)
-	out <- strings.Join(results, "_")
+	combineWindowed(in, out, GlobalWindow())
 }
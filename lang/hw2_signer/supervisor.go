@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+type SupervisorPolicy int
+
+const (
+	// AbortPipeline stops the whole pipeline on the first failure.
+	AbortPipeline SupervisorPolicy = iota
+	// RestartStage restarts a panicked stage up to MaxRestarts times
+	// and drops items that failed inside a stage.
+	RestartStage
+	// SkipItem drops the offending item and keeps the stage running.
+	// A stage that panics again without taking any new input is aborted.
+	SkipItem
+)
+
+type Supervisor struct {
+	Policy      SupervisorPolicy
+	MaxRestarts int
+	// OnError is called for every failure, including recovered ones.
+	// It may be called concurrently from different stages.
+	OnError func(*StageError)
+}
+
+var DefaultSupervisor = Supervisor{Policy: AbortPipeline}
+
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// ItemError is sent by a stage instead of a result when a single item failed.
+// Supervised pipelines and topologies never pass it to the next stage.
+type ItemError struct {
+	Item interface{}
+	Err  error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("item %v: %v", e.Item, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+type StageError struct {
+	Stage int
+	Name  string
+	Item  interface{}
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	if e.Item != nil {
+		return fmt.Sprintf("stage %d (%s) failed on item %v: %v", e.Stage, e.Name, e.Item, e.Err)
+	}
+	return fmt.Sprintf("stage %d (%s) failed: %v", e.Stage, e.Name, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverItem sends a panic of a goroutine working on item as an ItemError,
+// it should be deferred by the goroutine.
+func recoverItem(item interface{}, out chan interface{}) {
+	if r := recover(); r != nil {
+		out <- &ItemError{item, &PanicError{r, debug.Stack()}}
+	}
+}
+
+// passItemError sends val on if it is an ItemError of an earlier stage.
+// Stages connected without ExecuteStages may get those as input,
+// they pass them on instead of treating the error text as data.
+func passItemError(val interface{}, out chan interface{}) bool {
+	if itemErr, ok := val.(*ItemError); ok {
+		out <- itemErr
+		return true
+	}
+	return false
+}
+
+func runProtected(pipe job, in, out chan interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{r, debug.Stack()}
+		}
+	}()
+	pipe(in, out)
+	return nil
+}
+
+type pipelineRun struct {
+	supervisor Supervisor
+	abort      chan struct{}
+	once       sync.Once
+	err        error
+}
+
+// ExecuteSupervisedPipeline works like ExecutePipeline but recovers panics
+// in stages and handles them according to the supervisor policy.
+// It returns the error that aborted the pipeline, if any.
+func ExecuteSupervisedPipeline(s Supervisor, jobs ...job) error {
+	stages := make([]PipelineStage, len(jobs))
+	for i, pipe := range jobs {
+		stages[i].Job = pipe
+	}
+	return ExecuteStages(s, stages...)
+}
+
+// PipelineStage describes a stage of ExecuteStages.
+type PipelineStage struct {
+	Job job
+	// Name of the stage in StageError, the job function name if not set.
+	Name string
+	// Buffer is the capacity of the stage input, so the previous stage can
+	// run ahead by up to Buffer items instead of lock-step handoff.
+	// Buffered items count as taken by the stage for SkipItem.
+	Buffer int
+}
+
+// ExecuteStages is ExecuteSupervisedPipeline with options for every stage.
+func ExecuteStages(s Supervisor, stages ...PipelineStage) error {
+	r := &pipelineRun{supervisor: s, abort: make(chan struct{})}
+	finished := make(chan struct{})
+	in := make(chan interface{})
+	go func(in chan interface{}) {
+		select {
+		case <-r.abort:
+			close(in)
+		case <-finished:
+		}
+	}(in)
+
+	wg := &sync.WaitGroup{}
+	consumed := new(int64)
+	for i, stage := range stages {
+		pipe := stage.Job
+		out := make(chan interface{})
+		var next chan interface{}
+		if i < len(stages)-1 {
+			size := stages[i+1].Buffer
+			if size < 0 {
+				size = 0
+			}
+			next = make(chan interface{}, size)
+		}
+		nextConsumed := new(int64)
+		name := stage.Name
+		if name == "" {
+			name = jobName(pipe)
+		}
+
+		wg.Add(2)
+		go func(i int, pipe job, in, out chan interface{}, consumed *int64) {
+			defer wg.Done()
+			r.runStage(i, name, pipe, in, out, consumed)
+		}(i, pipe, in, out, consumed)
+		go func(i int, out, next chan interface{}, consumed *int64) {
+			defer wg.Done()
+			r.forward(i, name, out, next, consumed)
+		}(i, out, next, nextConsumed)
+
+		in, consumed = next, nextConsumed
+	}
+	wg.Wait()
+	close(finished)
+	return r.err
+}
+
+func (r *pipelineRun) fail(e *StageError, fatal bool) {
+	if r.supervisor.OnError != nil {
+		r.supervisor.OnError(e)
+	}
+	if fatal {
+		r.once.Do(func() {
+			r.err = e
+			close(r.abort)
+		})
+	}
+}
+
+// runStage runs the stage until it returns, then closes out. Every run sends
+// to its own channel forwarded into out, so goroutines left by a panicked run
+// may still send while the stage is restarted. Once out is closed they block.
+func (r *pipelineRun) runStage(i int, name string, pipe job, in, out chan interface{}, consumed *int64) {
+	done := make(chan struct{})
+	forwarders := &sync.WaitGroup{}
+	defer func() {
+		close(done)
+		forwarders.Wait()
+		close(out)
+	}()
+
+	restarts := 0
+	for {
+		before := atomic.LoadInt64(consumed)
+		runOut := make(chan interface{})
+		forwarders.Add(1)
+		go func() {
+			defer forwarders.Done()
+			for {
+				select {
+				case val := <-runOut:
+					out <- val
+				case <-done:
+					return
+				}
+			}
+		}()
+		err := runProtected(pipe, in, runOut)
+		if err == nil {
+			return
+		}
+		e := &StageError{Stage: i, Name: name, Err: err}
+		fatal := true
+		switch r.supervisor.Policy {
+		case RestartStage:
+			restarts++
+			fatal = restarts > r.supervisor.MaxRestarts
+		case SkipItem:
+			fatal = atomic.LoadInt64(consumed) == before
+		}
+		r.fail(e, fatal)
+		if fatal {
+			return
+		}
+		select {
+		case <-r.abort:
+			return
+		default:
+		}
+	}
+}
+
+// forward passes results of stage i to the next stage, intercepting item errors.
+// After an abort it closes the next stage input and drains the stage output.
+func (r *pipelineRun) forward(i int, name string, out, next chan interface{}, consumed *int64) {
+	closed := next == nil
+	closeNext := func() {
+		if !closed {
+			close(next)
+			closed = true
+		}
+	}
+	defer closeNext()
+
+	abort := r.abort
+	for {
+		select {
+		case val, ok := <-out:
+			if !ok {
+				return
+			}
+			if itemErr, ok := val.(*ItemError); ok {
+				e := &StageError{Stage: i, Name: name, Item: itemErr.Item, Err: itemErr.Err}
+				r.fail(e, r.supervisor.Policy == AbortPipeline)
+				continue
+			}
+			if closed {
+				continue
+			}
+			select {
+			case next <- val:
+				atomic.AddInt64(consumed, 1)
+			case <-r.abort:
+				closeNext()
+			}
+		case <-abort:
+			closeNext()
+			abort = nil
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPipelinePanicAborts(t *testing.T) {
+	var collected []interface{}
+	err := ExecutePipeline(
+		numbers(100),
+		job(func(in, out chan interface{}) {
+			for val := range in {
+				if val.(int) == 3 {
+					panic("bad value")
+				}
+				out <- val
+			}
+		}),
+		job(func(in, out chan interface{}) {
+			for val := range in {
+				collected = append(collected, val)
+			}
+		}),
+	)
+
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) || stageErr.Stage != 1 {
+		t.Fatalf("expected error of stage 1, got %v", err)
+	}
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) || panicErr.Value != "bad value" {
+		t.Fatalf("expected panic error, got %v", err)
+	}
+	if !strings.Contains(string(panicErr.Stack), "TestPipelinePanicAborts") {
+		t.Errorf("stack trace not recorded:\n%s", panicErr.Stack)
+	}
+	// items already in flight may be dropped on abort, nothing after the panic may pass
+	if len(collected) > 3 {
+		t.Errorf("items after the panic should not pass: %v", collected)
+	}
+}
+
+func TestPipelineSkipSignerPanic(t *testing.T) {
+	var md5Calls, crc32Calls uint32
+	withFastSigners(t, &md5Calls, &crc32Calls)
+	fastCrc32 := DataSignerCrc32
+	DataSignerCrc32 = func(data string) string {
+		if strings.HasSuffix(data, "~709660146") {
+			panic("crc32 overheat")
+		}
+		return fastCrc32(data)
+	}
+
+	mu := &sync.Mutex{}
+	var failures []*StageError
+	var result interface{}
+	err := ExecuteSupervisedPipeline(Supervisor{
+		Policy: SkipItem,
+		OnError: func(e *StageError) {
+			mu.Lock()
+			failures = append(failures, e)
+			mu.Unlock()
+		},
+	}, numbers(3), SingleHash, MultiHash, CombineResults, func(in, out chan interface{}) {
+		result = <-in
+	})
+
+	if err != nil {
+		t.Fatalf("skipped items should not abort the pipeline: %v", err)
+	}
+	if len(failures) != 1 || failures[0].Name != "MultiHash" || failures[0].Item != "2212294583~709660146" {
+		t.Fatalf("wrong failures: %v", failures)
+	}
+	if parts := strings.Split(result.(string), "_"); len(parts) != 2 {
+		t.Errorf("failed item should be skipped: %v", result)
+	}
+}
+
+func TestPipelineRestartStage(t *testing.T) {
+	var restarts int
+	var collected []interface{}
+	err := ExecuteSupervisedPipeline(Supervisor{
+		Policy:      RestartStage,
+		MaxRestarts: 3,
+		OnError: func(e *StageError) {
+			restarts++
+		},
+	},
+		numbers(10),
+		job(func(in, out chan interface{}) {
+			for val := range in {
+				if val.(int)%4 == 1 {
+					panic("bad value")
+				}
+				out <- val
+			}
+		}),
+		job(func(in, out chan interface{}) {
+			for val := range in {
+				collected = append(collected, val)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("stage should be restarted: %v", err)
+	}
+	if restarts != 3 || len(collected) != 7 {
+		t.Errorf("wrong restarts %v or collected items %v", restarts, collected)
+	}
+
+	err = ExecuteSupervisedPipeline(Supervisor{Policy: RestartStage, MaxRestarts: 1},
+		numbers(10),
+		job(func(in, out chan interface{}) {
+			for range in {
+				panic("always")
+			}
+		}),
+	)
+	if err == nil {
+		t.Errorf("pipeline should be aborted after max restarts")
+	}
+}
+
+func TestPipelineRestartLeftovers(t *testing.T) {
+	release := make(chan struct{})
+	sent := make(chan bool)
+	runs := 0
+	var collected []interface{}
+	err := ExecuteSupervisedPipeline(Supervisor{Policy: RestartStage, MaxRestarts: 1},
+		numbers(3),
+		job(func(in, out chan interface{}) {
+			runs++
+			if runs == 1 {
+				// a goroutine of the panicked run sends after the stage is done
+				go func() {
+					<-release
+					select {
+					case out <- "late":
+						sent <- true
+					case <-time.After(50 * time.Millisecond):
+						sent <- false
+					}
+				}()
+				panic("first run")
+			}
+			for val := range in {
+				out <- val
+			}
+		}),
+		job(func(in, out chan interface{}) {
+			for val := range in {
+				collected = append(collected, val)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("stage should be restarted: %v", err)
+	}
+	close(release)
+	if <-sent {
+		t.Errorf("late send of a finished stage should not be taken")
+	}
+	if len(collected) != 3 {
+		t.Errorf("wrong collected items: %v", collected)
+	}
+}
+
+func TestPipelineWorkerPanic(t *testing.T) {
+	var failures []*StageError
+	var collected []interface{}
+	err := ExecuteSupervisedPipeline(Supervisor{
+		Policy: SkipItem,
+		OnError: func(e *StageError) {
+			failures = append(failures, e)
+		},
+	},
+		numbers(3),
+		job(func(in, out chan interface{}) {
+			wg := &sync.WaitGroup{}
+			defer wg.Wait()
+			for val := range in {
+				wg.Add(1)
+				go func(val interface{}) {
+					defer wg.Done()
+					defer recoverItem(val, out)
+					if val == 1 {
+						panic("bad worker")
+					}
+					out <- val
+				}(val)
+			}
+		}),
+		job(func(in, out chan interface{}) {
+			for val := range in {
+				collected = append(collected, val)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("worker panic should skip the item: %v", err)
+	}
+	var panicErr *PanicError
+	if len(failures) != 1 || failures[0].Item != 1 || !errors.As(failures[0], &panicErr) {
+		t.Errorf("worker panic should be reported as item failure: %v", failures)
+	}
+	if len(collected) != 2 {
+		t.Errorf("wrong collected items: %v", collected)
+	}
+}
+
+func TestPipelineSkipWithoutProgress(t *testing.T) {
+	err := ExecuteSupervisedPipeline(Supervisor{Policy: SkipItem},
+		job(func(in, out chan interface{}) {
+			panic("broken source")
+		}),
+		job(CombineResults),
+	)
+	if err == nil {
+		t.Errorf("stage panicking without input should abort the pipeline")
+	}
+}
+
+func TestStagesPassItemErrors(t *testing.T) {
+	var md5Calls, crc32Calls uint32
+	withFastSigners(t, &md5Calls, &crc32Calls)
+
+	itemErr := &ItemError{"0", errors.New("failed upstream")}
+	in := make(chan interface{}, 2)
+	in <- itemErr
+	in <- 1
+	close(in)
+	multi := make(chan interface{}, 2)
+	MultiHash(in, multi)
+	close(multi)
+	out := make(chan interface{}, 2)
+	CombineResults(multi, out)
+	close(out)
+
+	var results []interface{}
+	for val := range out {
+		results = append(results, val)
+	}
+	if len(results) != 2 || results[0] != itemErr {
+		t.Fatalf("item error should be passed on as is: %v", results)
+	}
+	if res, ok := results[1].(string); !ok || strings.Contains(res, "failed") || crc32Calls != 6 {
+		t.Errorf("item error should not be signed: %v, %v crc32 calls", results[1], crc32Calls)
+	}
+}
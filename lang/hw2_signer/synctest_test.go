@@ -0,0 +1,67 @@
+//go:build go1.25
+
+package main
+
+import (
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+// signers from common.go, TestSigner replaces them for good
+var defaultLock, defaultUnlock, defaultMd5, defaultCrc32 = OverheatLock, OverheatUnlock, DataSignerMd5, DataSignerCrc32
+
+func withDefaultSigners(t *testing.T) {
+	lock, unlock, md5Sign, crc32Sign := OverheatLock, OverheatUnlock, DataSignerMd5, DataSignerCrc32
+	OverheatLock, OverheatUnlock, DataSignerMd5, DataSignerCrc32 = defaultLock, defaultUnlock, defaultMd5, defaultCrc32
+	t.Cleanup(func() {
+		OverheatLock, OverheatUnlock, DataSignerMd5, DataSignerCrc32 = lock, unlock, md5Sign, crc32Sign
+	})
+}
+
+// TestSignerVirtualTime runs the signers of common.go in a bubble,
+// their sleeps take virtual time.
+func TestSignerVirtualTime(t *testing.T) {
+	withDefaultSigners(t)
+	testExpected := "1173136728138862632818075107442090076184424490584241521304_1696913515191343735512658979631549563179965036907783101867_27225454331033649287118297354036464389062965355426795162684_29568666068035183841425683795340791879727309630931025356555_3994492081516972096677631278379039212655368881548151736_4958044192186797981418233587017209679042592862002427381542_4958044192186797981418233587017209679042592862002427381542"
+	var testResult interface{}
+	var elapsed time.Duration
+
+	md5Throttle, crc32Throttle := Md5Throttle, Crc32Throttle
+	defer func() {
+		Md5Throttle, Crc32Throttle = md5Throttle, crc32Throttle
+	}()
+
+	realStart := time.Now()
+	synctest.Test(t, func(t *testing.T) {
+		// channels made outside the bubble would not count as blocking it
+		Md5Throttle, Crc32Throttle = NewThrottle(1, 0, 0), NewThrottle(0, 0, 0)
+		start := time.Now()
+		ExecutePipeline(
+			job(func(in, out chan interface{}) {
+				for _, fibNum := range []int{0, 1, 1, 2, 3, 5, 8} {
+					out <- fibNum
+				}
+			}),
+			job(SingleHash),
+			job(MultiHash),
+			job(CombineResults),
+			job(func(in, out chan interface{}) {
+				testResult = <-in
+			}),
+		)
+		elapsed = time.Since(start)
+	})
+
+	if testResult != testExpected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", testResult, testExpected)
+	}
+	// one crc32 round in SingleHash, one in MultiHash and 7 sequential md5 calls,
+	// an overheat of md5 would sleep for another second
+	if elapsed > 3*time.Second || elapsed < 2*time.Second {
+		t.Errorf("wrong virtual execution time: %s", elapsed)
+	}
+	if real := time.Since(realStart); real > time.Second {
+		t.Errorf("virtual time test took too long: %s", real)
+	}
+}
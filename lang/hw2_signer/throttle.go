@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Throttle limits both the number of concurrent calls (semaphore) and their
+// rate (token bucket). Zero concurrency or qps means no limit.
+type Throttle struct {
+	sem   chan struct{}
+	clock Clock
+
+	mu     sync.Mutex
+	qps    float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	stats  ThrottleStats
+}
+
+type ThrottleStats struct {
+	Acquired  int64
+	Waited    int64
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+func (s ThrottleStats) AvgWait() time.Duration {
+	if s.Acquired == 0 {
+		return 0
+	}
+	return s.TotalWait / time.Duration(s.Acquired)
+}
+
+func (s ThrottleStats) String() string {
+	return fmt.Sprintf("acquired=%d waited=%d total=%s avg=%s max=%s",
+		s.Acquired, s.Waited, s.TotalWait, s.AvgWait(), s.MaxWait)
+}
+
+func NewThrottle(concurrency int, qps float64, burst int) *Throttle {
+	return NewThrottleWithClock(nil, concurrency, qps, burst)
+}
+
+// NewThrottleWithClock makes a throttle measuring time with clock.
+func NewThrottleWithClock(clock Clock, concurrency int, qps float64, burst int) *Throttle {
+	clock = clockOrReal(clock)
+	t := &Throttle{clock: clock, qps: qps, burst: float64(burst), last: clock.Now()}
+	if concurrency > 0 {
+		t.sem = make(chan struct{}, concurrency)
+	}
+	if t.burst < 1 {
+		t.burst = 1
+	}
+	t.tokens = t.burst
+	return t
+}
+
+// Acquire blocks until a slot and a token are available and returns
+// how long the caller waited.
+func (t *Throttle) Acquire() time.Duration {
+	wait, _ := t.AcquireContext(context.Background())
+	return wait
+}
+
+// AcquireContext is Acquire that gives up when ctx is done,
+// then it returns ctx error and holds neither a slot nor a token.
+func (t *Throttle) AcquireContext(ctx context.Context) (time.Duration, error) {
+	start := t.clock.Now()
+	if t.sem != nil {
+		select {
+		case t.sem <- struct{}{}:
+		case <-ctx.Done():
+			return t.clock.Now().Sub(start), ctx.Err()
+		}
+	}
+	if delay := t.reserve(); delay > 0 {
+		select {
+		case <-t.clock.After(delay):
+		case <-ctx.Done():
+			t.unreserve()
+			t.Release()
+			return t.clock.Now().Sub(start), ctx.Err()
+		}
+	}
+	wait := t.clock.Now().Sub(start)
+	t.record(wait)
+	return wait, nil
+}
+
+func (t *Throttle) Release() {
+	if t.sem == nil {
+		return
+	}
+	select {
+	case <-t.sem:
+	default:
+		panic("throttle: release without acquire")
+	}
+}
+
+func (t *Throttle) Do(f func()) {
+	t.Acquire()
+	defer t.Release()
+	f()
+}
+
+func (t *Throttle) Stats() ThrottleStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats
+}
+
+// reserve takes a token from the bucket and returns how long the caller has
+// to sleep until it is actually available. Tokens may go negative, so
+// waiters get spread over time without polling.
+func (t *Throttle) reserve() time.Duration {
+	if t.qps <= 0 {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.clock.Now()
+	if elapsed := now.Sub(t.last); elapsed > 0 {
+		t.tokens += elapsed.Seconds() * t.qps
+	}
+	if t.tokens > t.burst {
+		t.tokens = t.burst
+	}
+	t.last = now
+	t.tokens--
+	if t.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-t.tokens / t.qps * float64(time.Second))
+}
+
+// unreserve gives back the token of a cancelled wait.
+func (t *Throttle) unreserve() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tokens++
+}
+
+func (t *Throttle) record(wait time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats.Acquired++
+	t.stats.TotalWait += wait
+	if wait <= time.Millisecond {
+		return
+	}
+	t.stats.Waited++
+	if wait > t.stats.MaxWait {
+		t.stats.MaxWait = wait
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestThrottleConcurrency(t *testing.T) {
+	th := NewThrottle(2, 0, 0)
+	var active, maxActive int32
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			th.Do(func() {
+				cur := atomic.AddInt32(&active, 1)
+				for {
+					max := atomic.LoadInt32(&maxActive)
+					if cur <= max || atomic.CompareAndSwapInt32(&maxActive, max, cur) {
+						break
+					}
+				}
+				time.Sleep(20 * time.Millisecond)
+				atomic.AddInt32(&active, -1)
+			})
+		}()
+	}
+	wg.Wait()
+
+	if maxActive != 2 {
+		t.Errorf("concurrency limit not respected: %v", maxActive)
+	}
+	stats := th.Stats()
+	if stats.Acquired != 6 {
+		t.Errorf("wrong acquired count: %v", stats.Acquired)
+	}
+	if stats.Waited == 0 || stats.MaxWait < 20*time.Millisecond {
+		t.Errorf("wait times not reported: %v", stats)
+	}
+}
+
+func TestThrottleRate(t *testing.T) {
+	th := NewThrottle(0, 50, 1)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		th.Acquire()
+		th.Release()
+	}
+	// first token is available immediately, next 5 come every 20ms
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("rate limit not respected: %s", elapsed)
+	}
+}
+
+func TestThrottleReleaseWithoutAcquire(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic on release without acquire")
+		}
+	}()
+	NewThrottle(1, 0, 0).Release()
+}
+
+func TestThrottleCancelReturnsToken(t *testing.T) {
+	clock := NewFakeClock()
+	th := NewThrottleWithClock(clock, 0, 10, 1)
+	th.Acquire()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 10; i++ {
+		if _, err := th.AcquireContext(ctx); err != context.Canceled {
+			t.Errorf("cancelled wait should fail: %v", err)
+		}
+	}
+	// the cancelled waits leave the next token 100ms away
+	clock.Advance(100 * time.Millisecond)
+	if _, err := th.AcquireContext(ctx); err != nil {
+		t.Errorf("cancelled waits should give their tokens back: %v", err)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+type nodeKind int
+
+const (
+	stageNode nodeKind = iota
+	mergeNode
+	teeNode
+	filterNode
+	routeNode
+	branchNode
+)
+
+// Topology describes a pipeline as a DAG of stages. Nodes can only take
+// inputs from already created nodes, so the graph has no cycles by construction.
+// Every node output may be consumed only once, use Tee to share a stream.
+// Outputs that are not consumed by anyone are drained and discarded.
+type Topology struct {
+	// OnError is called for every item failed by a stage, failed items are
+	// not passed downstream. It may be called concurrently from different stages.
+	OnError func(*StageError)
+
+	nodes []*Node
+	mu    sync.Mutex
+	err   error
+}
+
+type Node struct {
+	topology *Topology
+	id       int
+	name     string
+	kind     nodeKind
+	job      job
+	workers  int
+	inputs   []*Node
+	filter   func(interface{}) bool
+	routes   []func(interface{}) bool
+	branches []*Node
+	parent   *Node
+	label    string
+	out      chan interface{}
+}
+
+func NewTopology() *Topology {
+	return &Topology{}
+}
+
+func (n *Node) Name() string {
+	return n.name
+}
+
+func (t *Topology) add(n *Node) *Node {
+	n.topology = t
+	n.id = len(t.nodes)
+	t.nodes = append(t.nodes, n)
+	return n
+}
+
+// Stage runs a job reading from the given nodes. Several inputs are merged,
+// a stage without inputs is a source and gets a closed input channel.
+func (t *Topology) Stage(name string, j job, from ...*Node) *Node {
+	return t.FanOut(name, j, 1, from...)
+}
+
+// FanOut runs workers copies of the job sharing the same input and output,
+// so items are processed in parallel and may be reordered.
+func (t *Topology) FanOut(name string, j job, workers int, from ...*Node) *Node {
+	if name == "" {
+		name = jobName(j)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	return t.add(&Node{name: name, kind: stageNode, job: j, workers: workers, inputs: from})
+}
+
+func (t *Topology) Merge(name string, from ...*Node) *Node {
+	return t.add(&Node{name: name, kind: mergeNode, inputs: from})
+}
+
+func (t *Topology) Filter(name string, pred func(interface{}) bool, from *Node) *Node {
+	return t.add(&Node{name: name, kind: filterNode, filter: pred, inputs: []*Node{from}})
+}
+
+// Tee copies every item to count branches. A slow branch blocks the others.
+func (t *Topology) Tee(name string, count int, from *Node) []*Node {
+	tee := t.add(&Node{name: name, kind: teeNode, inputs: []*Node{from}})
+	for i := 0; i < count; i++ {
+		t.branch(tee, fmt.Sprint(i))
+	}
+	return tee.branches
+}
+
+// Route sends every item to the branch of the first matching predicate.
+// It returns len(preds)+1 branches, the last one gets unmatched items.
+func (t *Topology) Route(name string, from *Node, preds ...func(interface{}) bool) []*Node {
+	route := t.add(&Node{name: name, kind: routeNode, routes: preds, inputs: []*Node{from}})
+	for i := range preds {
+		t.branch(route, fmt.Sprint(i))
+	}
+	t.branch(route, "default")
+	return route.branches
+}
+
+func (t *Topology) branch(parent *Node, label string) {
+	b := t.add(&Node{name: parent.name + "[" + label + "]", kind: branchNode, parent: parent, label: label})
+	parent.branches = append(parent.branches, b)
+}
+
+func (t *Topology) validate() error {
+	consumers := make(map[*Node]*Node)
+	for _, n := range t.nodes {
+		for _, in := range n.inputs {
+			if in == nil || in.topology != t {
+				return fmt.Errorf("node %q has input from another topology", n.name)
+			}
+			if in.kind == teeNode || in.kind == routeNode {
+				return fmt.Errorf("node %q reads %q directly, use its branches", n.name, in.name)
+			}
+			if prev, ok := consumers[in]; ok {
+				return fmt.Errorf("node %q consumed by both %q and %q, use Tee to share a stream", in.name, prev.name, n.name)
+			}
+			consumers[in] = n
+		}
+	}
+	return nil
+}
+
+// Run executes all nodes and blocks until every one of them is finished.
+// It returns the first item failure as *StageError, the other items
+// keep running.
+func (t *Topology) Run() error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	t.err = nil
+	consumed := make(map[*Node]bool)
+	for _, n := range t.nodes {
+		n.out = make(chan interface{})
+		for _, in := range n.inputs {
+			consumed[in] = true
+		}
+	}
+
+	wg := &sync.WaitGroup{}
+	for _, n := range t.nodes {
+		if n.kind == branchNode {
+			continue
+		}
+		wg.Add(1)
+		go func(n *Node, in chan interface{}) {
+			defer wg.Done()
+			n.run(in)
+		}(n, mergeInputs(n.inputs))
+	}
+	for _, n := range t.nodes {
+		if consumed[n] || n.kind == teeNode || n.kind == routeNode {
+			continue
+		}
+		wg.Add(1)
+		go func(out chan interface{}) {
+			defer wg.Done()
+			for range out {
+			}
+		}(n.out)
+	}
+	wg.Wait()
+	return t.err
+}
+
+func (t *Topology) fail(e *StageError) {
+	if t.OnError != nil {
+		t.OnError(e)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		t.err = e
+	}
+}
+
+func mergeInputs(inputs []*Node) chan interface{} {
+	switch len(inputs) {
+	case 0:
+		in := make(chan interface{})
+		close(in)
+		return in
+	case 1:
+		return inputs[0].out
+	}
+	in := make(chan interface{})
+	wg := &sync.WaitGroup{}
+	for _, input := range inputs {
+		wg.Add(1)
+		go func(out chan interface{}) {
+			defer wg.Done()
+			for val := range out {
+				in <- val
+			}
+		}(input.out)
+	}
+	go func() {
+		wg.Wait()
+		close(in)
+	}()
+	return in
+}
+
+func (n *Node) run(in chan interface{}) {
+	switch n.kind {
+	case stageNode:
+		jobOut := make(chan interface{})
+		wg := &sync.WaitGroup{}
+		for i := 0; i < n.workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				n.job(in, jobOut)
+			}()
+		}
+		go func() {
+			wg.Wait()
+			close(jobOut)
+		}()
+		for val := range jobOut {
+			if itemErr, ok := val.(*ItemError); ok {
+				n.topology.fail(&StageError{Stage: n.id, Name: n.name, Item: itemErr.Item, Err: itemErr.Err})
+				continue
+			}
+			n.out <- val
+		}
+	case mergeNode:
+		for val := range in {
+			n.out <- val
+		}
+	case filterNode:
+		for val := range in {
+			if n.filter(val) {
+				n.out <- val
+			}
+		}
+	case teeNode:
+		for val := range in {
+			for _, b := range n.branches {
+				b.out <- val
+			}
+		}
+	case routeNode:
+		for val := range in {
+			i := 0
+			for i < len(n.routes) && !n.routes[i](val) {
+				i++
+			}
+			n.branches[i].out <- val
+		}
+	}
+	for _, b := range n.branches {
+		close(b.out)
+	}
+	close(n.out)
+}
+
+func (t *Topology) WriteDOT(w io.Writer) error {
+	b := &strings.Builder{}
+	b.WriteString("digraph pipeline {\n\trankdir=LR;\n")
+	for _, n := range t.nodes {
+		label, shape := n.name, "box"
+		switch n.kind {
+		case stageNode:
+			if len(n.inputs) == 0 {
+				shape = "invhouse"
+			}
+			if n.workers > 1 {
+				label, shape = fmt.Sprintf("%s x%d", n.name, n.workers), "box3d"
+			}
+		case mergeNode:
+			shape = "invtriangle"
+		case teeNode:
+			shape = "triangle"
+		case filterNode, routeNode:
+			shape = "diamond"
+		case branchNode:
+			continue
+		}
+		fmt.Fprintf(b, "\tn%d [label=%q, shape=%s];\n", n.id, label, shape)
+	}
+	for _, n := range t.nodes {
+		for _, in := range n.inputs {
+			if in.kind == branchNode {
+				fmt.Fprintf(b, "\tn%d -> n%d [label=%q];\n", in.parent.id, n.id, in.label)
+			} else {
+				fmt.Fprintf(b, "\tn%d -> n%d;\n", in.id, n.id)
+			}
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (t *Topology) DOT() string {
+	b := &strings.Builder{}
+	t.WriteDOT(b)
+	return b.String()
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func numbers(count int) job {
+	return func(in, out chan interface{}) {
+		for i := 0; i < count; i++ {
+			out <- i
+		}
+	}
+}
+
+type collector struct {
+	mu     sync.Mutex
+	values []int
+}
+
+func (c *collector) job(in, out chan interface{}) {
+	for val := range in {
+		c.mu.Lock()
+		c.values = append(c.values, val.(int))
+		c.mu.Unlock()
+	}
+}
+
+func (c *collector) sorted() []int {
+	sort.Ints(c.values)
+	return c.values
+}
+
+func TestTopologyFanOut(t *testing.T) {
+	var md5Calls, crc32Calls uint32
+	withFastSigners(t, &md5Calls, &crc32Calls)
+
+	var linear, parallel interface{}
+	ExecutePipeline(numbers(7), SingleHash, MultiHash, CombineResults, func(in, out chan interface{}) {
+		linear = <-in
+	})
+
+	topo := NewTopology()
+	src := topo.Stage("numbers", numbers(7))
+	single := topo.FanOut("", SingleHash, 3, src)
+	multi := topo.FanOut("", MultiHash, 4, single)
+	combined := topo.Stage("", CombineResults, multi)
+	topo.Stage("result", func(in, out chan interface{}) {
+		parallel = <-in
+	}, combined)
+	if err := topo.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if linear != parallel {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", parallel, linear)
+	}
+}
+
+func TestTopologyRouteTeeMerge(t *testing.T) {
+	topo := NewTopology()
+	src := topo.Stage("numbers", numbers(10))
+	routes := topo.Route("parity", src, func(val interface{}) bool {
+		return val.(int)%2 == 0
+	})
+	evens := topo.Tee("copy", 2, routes[0])
+	big := topo.Filter("big", func(val interface{}) bool {
+		return val.(int) > 5
+	}, evens[1])
+	merged := topo.Merge("merge", routes[1], big)
+
+	evenValues, mergedValues := &collector{}, &collector{}
+	topo.Stage("evens", evenValues.job, evens[0])
+	topo.Stage("merged", mergedValues.job, merged)
+	if err := topo.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := evenValues.sorted(); len(got) != 5 || got[0] != 0 || got[4] != 8 {
+		t.Errorf("wrong even branch: %v", got)
+	}
+	expected := []int{1, 3, 5, 6, 7, 8, 9}
+	got := mergedValues.sorted()
+	if len(got) != len(expected) {
+		t.Fatalf("wrong merged values: %v", got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("wrong merged values: %v", got)
+			break
+		}
+	}
+}
+
+func TestTopologyValidate(t *testing.T) {
+	topo := NewTopology()
+	src := topo.Stage("numbers", numbers(1))
+	topo.Stage("a", CombineResults, src)
+	topo.Stage("b", CombineResults, src)
+	if err := topo.Run(); err == nil || !strings.Contains(err.Error(), "use Tee") {
+		t.Errorf("expected double consumption error, got %v", err)
+	}
+
+	other := NewTopology().Stage("numbers", numbers(1))
+	topo = NewTopology()
+	topo.Merge("merge", other)
+	if err := topo.Run(); err == nil {
+		t.Errorf("expected foreign node error")
+	}
+}
+
+func TestTopologyDOT(t *testing.T) {
+	topo := NewTopology()
+	src := topo.Stage("numbers", numbers(1))
+	branches := topo.Route("route", src, func(interface{}) bool { return true })
+	topo.FanOut("", MultiHash, 6, branches[0])
+	topo.Stage("", CombineResults, branches[1])
+
+	dot := topo.DOT()
+	for _, line := range []string{
+		"digraph pipeline {",
+		`n0 [label="numbers", shape=invhouse];`,
+		`n1 [label="route", shape=diamond];`,
+		`n4 [label="MultiHash x6", shape=box3d];`,
+		`n1 -> n4 [label="0"];`,
+		`n1 -> n5 [label="default"];`,
+		"n0 -> n1;",
+	} {
+		if !strings.Contains(dot, line) {
+			t.Errorf("DOT output has no line %q:\n%v", line, dot)
+		}
+	}
+}
+
+func TestTopologyItemErrors(t *testing.T) {
+	topo := NewTopology()
+	var failed []*StageError
+	mu := &sync.Mutex{}
+	topo.OnError = func(e *StageError) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = append(failed, e)
+	}
+	src := topo.Stage("numbers", numbers(5))
+	checked := topo.FanOut("check", func(in, out chan interface{}) {
+		for val := range in {
+			if val.(int)%2 == 1 {
+				out <- &ItemError{val, errors.New("odd")}
+				continue
+			}
+			out <- val
+		}
+	}, 2, src)
+	c := &collector{}
+	topo.Stage("collect", c.job, checked)
+
+	err := topo.Run()
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) || stageErr.Name != "check" || stageErr.Stage != checked.id {
+		t.Errorf("item failure should be returned with the stage: %v", err)
+	}
+	if len(failed) != 2 {
+		t.Errorf("every item failure should be reported: %v", failed)
+	}
+	if got := c.sorted(); len(got) != 3 || got[0] != 0 || got[1] != 2 || got[2] != 4 {
+		t.Errorf("failed items should not reach the next stage: %v", got)
+	}
+}
@@ -0,0 +1,186 @@
+package hw2workerpool
+
+import (
+	"sync"
+	"time"
+)
+
+// ScaleMetrics is the pool state an autoscaler policy decides on.
+type ScaleMetrics struct {
+	Size   int
+	Active int
+	// Queued counts submitted tasks and jobs buffered in the jobs channel.
+	Queued      int
+	Utilization float64
+	// Latency is the average queue wait of jobs started since the last check,
+	// jobs from the jobs channel wait from the moment the pool takes them.
+	Latency time.Duration
+}
+
+// ScalePolicy returns the desired pool size, the autoscaler keeps it
+// within its bounds and cooldowns.
+type ScalePolicy interface {
+	Desired(m ScaleMetrics) int
+}
+
+type ScalePolicyFunc func(m ScaleMetrics) int
+
+func (f ScalePolicyFunc) Desired(m ScaleMetrics) int {
+	return f(m)
+}
+
+// ThresholdPolicy adds Step workers when the pool is busy and tasks are waiting
+// or queue latency is above MaxLatency, and removes Step workers when the pool
+// is mostly idle and nothing is queued.
+type ThresholdPolicy struct {
+	HighUtilization float64
+	LowUtilization  float64
+	// MaxQueue is the backlog tolerated without scaling up.
+	MaxQueue int
+	// MaxLatency of the queue wait, 0 means not checked.
+	MaxLatency time.Duration
+	Step       int
+}
+
+var DefaultScalePolicy = ThresholdPolicy{HighUtilization: 0.8, LowUtilization: 0.3, Step: 1}
+
+func (p ThresholdPolicy) Desired(m ScaleMetrics) int {
+	step := p.Step
+	if step < 1 {
+		step = 1
+	}
+	switch {
+	case m.Utilization >= p.HighUtilization && m.Queued > p.MaxQueue:
+		return m.Size + step
+	case p.MaxLatency > 0 && m.Latency > p.MaxLatency:
+		return m.Size + step
+	case m.Utilization <= p.LowUtilization && m.Queued == 0:
+		return m.Size - step
+	}
+	return m.Size
+}
+
+type AutoscaleOptions struct {
+	Min      int
+	Max      int
+	Interval time.Duration
+	// UpCooldown and DownCooldown are the least time from the last resize
+	// to growing or shrinking the pool again.
+	UpCooldown   time.Duration
+	DownCooldown time.Duration
+	// Policy is DefaultScalePolicy if nil.
+	Policy ScalePolicy
+}
+
+type Autoscaler struct {
+	pool       *WorkerPool
+	opts       AutoscaleOptions
+	lastResize time.Time
+	window     latencyWindow
+	stop       chan struct{}
+	done       chan struct{}
+	once       sync.Once
+}
+
+// Autoscale starts resizing the pool every Interval until Stop is called.
+func (wp *WorkerPool) Autoscale(opts AutoscaleOptions) *Autoscaler {
+	a := newAutoscaler(wp, opts)
+	go a.loop()
+	return a
+}
+
+func newAutoscaler(wp *WorkerPool, opts AutoscaleOptions) *Autoscaler {
+	if opts.Min < 1 {
+		opts.Min = 1
+	}
+	if opts.Max < opts.Min {
+		opts.Max = opts.Min
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.Policy == nil {
+		opts.Policy = DefaultScalePolicy
+	}
+	return &Autoscaler{
+		pool: wp,
+		opts: opts,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+func (a *Autoscaler) loop() {
+	defer close(a.done)
+	ticker := time.NewTicker(a.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			a.tick(now)
+		case <-a.stop:
+			return
+		case <-a.pool.ctx.Done():
+			return
+		}
+	}
+}
+
+// Stop stops resizing, the pool keeps its current size.
+func (a *Autoscaler) Stop() {
+	a.once.Do(func() {
+		close(a.stop)
+	})
+	<-a.done
+}
+
+func (a *Autoscaler) tick(now time.Time) {
+	m := a.pool.scaleMetrics(&a.window)
+	desired := a.opts.Policy.Desired(m)
+	if desired < a.opts.Min {
+		desired = a.opts.Min
+	}
+	if desired > a.opts.Max {
+		desired = a.opts.Max
+	}
+	since := now.Sub(a.lastResize)
+	switch {
+	case desired > m.Size && since >= a.opts.UpCooldown:
+		a.pool.AddWorkers(desired - m.Size)
+	case desired < m.Size && since >= a.opts.DownCooldown:
+		if a.pool.DecWorkers(m.Size-desired) != nil {
+			return
+		}
+	default:
+		return
+	}
+	a.lastResize = now
+}
+
+// latencyWindow remembers the cumulative queue wait seen at the last check,
+// so every reader of the pool gets its own latency window.
+type latencyWindow struct {
+	sum   time.Duration
+	count int
+}
+
+// scaleMetrics takes the pool state, latency is averaged over jobs started
+// since the previous call with the same window.
+func (wp *WorkerPool) scaleMetrics(w *latencyWindow) ScaleMetrics {
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+	m := ScaleMetrics{
+		Size:   wp.size,
+		Active: wp.counter,
+		Queued: wp.queuedLocked() + len(wp.jobs),
+	}
+	if m.Size > 0 {
+		m.Utilization = float64(m.Active) / float64(m.Size)
+	}
+	if n := wp.waitCount - w.count; n > 0 {
+		m.Latency = (wp.waitSum - w.sum) / time.Duration(n)
+	}
+	w.sum = wp.waitSum
+	w.count = wp.waitCount
+	return m
+}
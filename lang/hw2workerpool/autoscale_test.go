@@ -0,0 +1,135 @@
+package hw2workerpool
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// load submits n tasks that run until release is closed.
+func load(wp *WorkerPool, n int) (release chan struct{}, futures []*Future) {
+	release = make(chan struct{})
+	for i := 0; i < n; i++ {
+		futures = append(futures, wp.Submit(func() (interface{}, error) {
+			<-release
+			return nil, nil
+		}))
+	}
+	return release, futures
+}
+
+func waitActive(t *testing.T, wp *WorkerPool, active int) {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		if wp.ActiveCount() == active {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("active count should be %d, got %d", active, wp.ActiveCount())
+}
+
+func TestAutoscalerSimulatedLoad(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	defer wp.Finish()
+	a := newAutoscaler(wp, AutoscaleOptions{Min: 1, Max: 4})
+
+	release, futures := load(wp, 10)
+	now := time.Now()
+	for i := 1; i <= 6; i++ {
+		waitActive(t, wp, wp.Size())
+		a.tick(now.Add(time.Duration(i) * time.Second))
+	}
+	if wp.Size() != 4 {
+		t.Errorf("busy pool with backlog should grow to max: %v", wp.Size())
+	}
+
+	close(release)
+	for _, f := range futures {
+		f.Wait(context.Background())
+	}
+	waitActive(t, wp, 0)
+	for i := 7; i <= 12; i++ {
+		a.tick(now.Add(time.Duration(i) * time.Second))
+	}
+	if wp.Size() != 1 {
+		t.Errorf("idle pool should shrink to min: %v", wp.Size())
+	}
+}
+
+func TestAutoscalerCooldown(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	defer wp.Finish()
+	a := newAutoscaler(wp, AutoscaleOptions{Min: 1, Max: 10, UpCooldown: time.Minute, DownCooldown: time.Hour})
+
+	release, _ := load(wp, 10)
+	defer close(release)
+	waitActive(t, wp, 1)
+	now := time.Now()
+	a.tick(now)
+	waitActive(t, wp, 2)
+	a.tick(now.Add(time.Second))
+	if wp.Size() != 2 {
+		t.Errorf("pool should not grow during cooldown: %v", wp.Size())
+	}
+	a.tick(now.Add(2 * time.Minute))
+	if wp.Size() != 3 {
+		t.Errorf("pool should grow after cooldown: %v", wp.Size())
+	}
+}
+
+func TestAutoscalerPolicy(t *testing.T) {
+	wp := StartWorkerPool(2, nil, nil)
+	defer wp.Finish()
+	var seen ScaleMetrics
+	a := newAutoscaler(wp, AutoscaleOptions{Min: 2, Max: 8, Policy: ScalePolicyFunc(func(m ScaleMetrics) int {
+		seen = m
+		return 100
+	})})
+
+	release, _ := load(wp, 5)
+	defer close(release)
+	waitActive(t, wp, 2)
+	time.Sleep(10 * time.Millisecond)
+	a.tick(time.Now())
+	if seen.Size != 2 || seen.Active != 2 || seen.Queued != 3 || seen.Utilization != 1 {
+		t.Errorf("wrong metrics passed to policy: %+v", seen)
+	}
+	if wp.Size() != 8 {
+		t.Errorf("desired size should be capped by max: %v", wp.Size())
+	}
+
+	waitActive(t, wp, 5)
+	wp.scaleMetrics(&latencyWindow{})
+	a.tick(time.Now())
+	if seen.Latency < 10*time.Millisecond {
+		t.Errorf("queue latency of started tasks should be measured: %v", seen.Latency)
+	}
+	a.tick(time.Now())
+	if seen.Latency != 0 {
+		t.Errorf("latency window should restart after a check: %v", seen.Latency)
+	}
+}
+
+func TestThresholdPolicyLatency(t *testing.T) {
+	p := ThresholdPolicy{HighUtilization: 0.9, LowUtilization: 0.1, MaxLatency: time.Second, Step: 2}
+	if size := p.Desired(ScaleMetrics{Size: 4, Active: 2, Utilization: 0.5, Latency: 2 * time.Second}); size != 6 {
+		t.Errorf("high latency should scale up: %v", size)
+	}
+	if size := p.Desired(ScaleMetrics{Size: 4, Active: 2, Utilization: 0.5}); size != 4 {
+		t.Errorf("moderate load should keep size: %v", size)
+	}
+}
+
+func TestAutoscale(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	defer wp.Finish()
+	a := wp.Autoscale(AutoscaleOptions{Min: 1, Max: 3, Interval: 5 * time.Millisecond})
+	release, _ := load(wp, 6)
+	waitActive(t, wp, 3)
+	a.Stop()
+	close(release)
+	if wp.Size() != 3 {
+		t.Errorf("running autoscaler should grow the pool: %v", wp.Size())
+	}
+}
@@ -0,0 +1,60 @@
+//go:build linux || darwin || freebsd
+
+package hw2workerpool
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func cpuTime() time.Duration {
+	usage := syscall.Rusage{}
+	syscall.Getrusage(syscall.RUSAGE_SELF, &usage)
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// BenchmarkIdleCPU reports CPU time burnt by an idle pool per 10ms of wall time.
+func BenchmarkIdleCPU(b *testing.B) {
+	jobs := make(chan func(chan interface{}))
+	results := make(chan interface{})
+	wp := StartWorkerPool(4, jobs, results)
+	defer wp.Finish()
+
+	b.ResetTimer()
+	start := cpuTime()
+	for i := 0; i < b.N; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	b.ReportMetric(float64(cpuTime()-start)/float64(b.N), "cpu-ns/op")
+}
+
+// BenchmarkDispatchLatency measures the time from sending a job to it starting.
+func BenchmarkDispatchLatency(b *testing.B) {
+	jobs := make(chan func(chan interface{}))
+	results := make(chan interface{})
+	wp := StartWorkerPool(4, jobs, results)
+	defer wp.Finish()
+
+	started := make(chan struct{})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		jobs <- func(chan interface{}) {
+			started <- struct{}{}
+		}
+		<-started
+	}
+}
+
+func TestIdleCPU(t *testing.T) {
+	jobs := make(chan func(chan interface{}))
+	results := make(chan interface{})
+	wp := StartWorkerPool(4, jobs, results)
+	defer wp.Finish()
+
+	start := cpuTime()
+	time.Sleep(200 * time.Millisecond)
+	if used := cpuTime() - start; used > 50*time.Millisecond {
+		t.Errorf("idle pool should not burn CPU, used %s in 200ms", used)
+	}
+}
@@ -0,0 +1,260 @@
+package hw2workerpool
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrPoolFinished = errors.New("worker pool finished")
+
+// Future is a handle of a submitted task, it is resolved once the task returns
+// or the pool finishes before the task was started.
+type Future struct {
+	done   chan struct{}
+	result interface{}
+	err    error
+	cancel func() bool
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+func (f *Future) resolve(result interface{}, err error) {
+	f.result = result
+	f.err = err
+	close(f.done)
+}
+
+// Done is closed when the result is ready.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the task is done or ctx is cancelled, in the latter case
+// ctx error is returned and the task keeps running.
+func (f *Future) Wait(ctx context.Context) (interface{}, error) {
+	select {
+	case <-f.done:
+		return f.result, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Cancel cancels the task context. A task that has not started yet is removed
+// from the queue and fails with context.Canceled, then Cancel returns true.
+func (f *Future) Cancel() bool {
+	if f.cancel == nil {
+		return false
+	}
+	return f.cancel()
+}
+
+// Result returns the task result, nil until the task is done.
+func (f *Future) Result() interface{} {
+	select {
+	case <-f.done:
+		return f.result
+	default:
+		return nil
+	}
+}
+
+// Err returns the task error, nil until the task is done.
+func (f *Future) Err() error {
+	select {
+	case <-f.done:
+		return f.err
+	default:
+		return nil
+	}
+}
+
+type task struct {
+	run    func(results chan interface{})
+	ctx    context.Context
+	cancel context.CancelFunc
+	timer  *time.Timer
+	future *Future
+
+	priority int
+	deadline time.Time
+	enqueued time.Time
+	seq      uint64
+	rank     int64
+	index    int
+	key      string
+	// durable is the journal record of the task, 0 if there is none.
+	durable uint64
+}
+
+// JobOptions are per-task settings of SubmitWith.
+type JobOptions struct {
+	// Deadline of the task context, a task still queued at the deadline
+	// fails with context.DeadlineExceeded without running. Among tasks
+	// of the same priority the earliest deadline runs first.
+	Deadline time.Time
+	// Priority orders queued tasks, higher runs first, default is 0.
+	Priority int
+	// Key partitions tasks: tasks with the same key run one at a time in
+	// submission order, tasks with different keys run concurrently.
+	Key string
+}
+
+// Submit queues f to run on a pool worker. Submitted tasks do not use
+// the results channel, their result and error are delivered by the Future.
+func (wp *WorkerPool) Submit(f func() (interface{}, error)) *Future {
+	return wp.SubmitWith(JobOptions{}, func(context.Context) (interface{}, error) {
+		return f()
+	})
+}
+
+// SubmitContext is Submit for tasks that take a context derived from the pool,
+// it is cancelled by Future.Cancel or by Finish with CancelInFlight.
+func (wp *WorkerPool) SubmitContext(f func(ctx context.Context) (interface{}, error)) *Future {
+	return wp.SubmitWith(JobOptions{}, f)
+}
+
+func (wp *WorkerPool) SubmitWith(opts JobOptions, f func(ctx context.Context) (interface{}, error)) *Future {
+	return wp.submit(opts, f, 0, true)
+}
+
+// submit queues a task with its journal record, limited tasks are subject
+// to the queue limit.
+func (wp *WorkerPool) submit(opts JobOptions, f func(ctx context.Context) (interface{}, error), durable uint64, limited bool) *Future {
+	future := newFuture()
+	t := &task{future: future, priority: opts.Priority, deadline: opts.Deadline, index: -1, key: opts.Key, durable: durable}
+	if opts.Deadline.IsZero() {
+		t.ctx, t.cancel = context.WithCancel(wp.jobCtx)
+	} else {
+		t.ctx, t.cancel = context.WithDeadline(wp.jobCtx, opts.Deadline)
+	}
+	t.run = func(chan interface{}) {
+		defer wp.keyDone(t)
+		wp.execute(t, f)
+	}
+	future.cancel = func() bool {
+		t.cancel()
+		return wp.unqueue(t, context.Canceled)
+	}
+
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+	for limited && wp.limit > 0 && wp.queuedLocked() >= wp.limit && !wp.closed && wp.ctx.Err() == nil {
+		if !wp.overflow(t, f) {
+			return future
+		}
+	}
+	if wp.closed || wp.ctx.Err() != nil {
+		wp.lock.Unlock()
+		t.cancel()
+		wp.forget(t)
+		future.resolve(nil, ErrPoolFinished)
+		wp.lock.Lock()
+		return future
+	}
+	wp.enqueue(t)
+	wp.submitted = wp.submitted + 1
+	if !opts.Deadline.IsZero() {
+		t.timer = time.AfterFunc(time.Until(opts.Deadline), func() {
+			wp.unqueue(t, context.DeadlineExceeded)
+		})
+	}
+	select {
+	case wp.queued <- struct{}{}:
+	default:
+	}
+	return future
+}
+
+// execute runs the task f and resolves its future, a panic is passed on
+// after the future is resolved.
+func (wp *WorkerPool) execute(t *task, f func(ctx context.Context) (interface{}, error)) {
+	defer t.cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			err := recovered(r)
+			t.future.resolve(nil, err)
+			panic(err)
+		}
+	}()
+	res, err := f(t.ctx)
+	if err != nil {
+		wp.taskFailed()
+	}
+	t.future.resolve(res, err)
+}
+
+// unqueue removes a task that has not started and fails it with err,
+// a durable task is acknowledged.
+func (wp *WorkerPool) unqueue(t *task, err error) bool {
+	wp.lock.Lock()
+	head := t.index >= 0
+	found := head
+	if head {
+		heap.Remove(&wp.queue, t.index)
+	} else if t.key != "" {
+		found = wp.removePending(t)
+	}
+	if found {
+		wp.broadcast()
+	}
+	wp.lock.Unlock()
+	if found {
+		t.stop()
+		wp.forget(t)
+		t.future.resolve(nil, err)
+	}
+	if head {
+		wp.keyDone(t)
+	}
+	return found
+}
+
+func (t *task) stop() {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.cancel()
+}
+
+// dequeue takes the next task together with a worker slot for it.
+func (wp *WorkerPool) dequeue() *task {
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+	if len(wp.queue) == 0 || wp.ctx.Err() != nil || (wp.closed && !wp.draining) {
+		return nil
+	}
+	t := heap.Pop(&wp.queue).(*task)
+	wp.counter = wp.counter + 1
+	wp.broadcast()
+	wp.observeWait(time.Since(t.enqueued))
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	return t
+}
+
+func (wp *WorkerPool) failQueued(err error) int {
+	wp.lock.Lock()
+	queue := wp.queue
+	wp.queue = nil
+	for _, t := range queue {
+		t.index = -1
+	}
+	for _, ks := range wp.keys {
+		queue = append(queue, ks.pending...)
+	}
+	wp.keys = make(map[string]*keyState)
+	wp.keyPending = 0
+	wp.broadcast()
+	wp.lock.Unlock()
+	for _, t := range queue {
+		t.stop()
+		t.future.resolve(nil, err)
+	}
+	return len(queue)
+}
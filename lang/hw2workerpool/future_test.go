@@ -0,0 +1,197 @@
+package hw2workerpool
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSubmit(t *testing.T) {
+	jobs := make(chan func(chan interface{}))
+	results := make(chan interface{}, 1)
+	wp := StartWorkerPool(2, jobs, results)
+	defer wp.Finish()
+
+	failure := errors.New("failure")
+	futures := make([]*Future, 10)
+	for i := range futures {
+		i := i
+		futures[i] = wp.Submit(func() (interface{}, error) {
+			if i == 3 {
+				return nil, failure
+			}
+			return i * i, nil
+		})
+	}
+	jobs <- func(results chan interface{}) {
+		results <- "raw"
+	}
+
+	for i, f := range futures {
+		res, err := f.Wait(context.Background())
+		if i == 3 {
+			if err != failure {
+				t.Errorf("task error should be returned, got %v", err)
+			}
+			continue
+		}
+		if err != nil || res != i*i {
+			t.Errorf("wrong result of task %d: %v, %v", i, res, err)
+		}
+		if f.Result() != i*i || f.Err() != nil {
+			t.Errorf("result should be kept by the future: %v", f.Result())
+		}
+	}
+	if res := <-results; res != "raw" {
+		t.Errorf("raw jobs should still use results channel: %v", res)
+	}
+}
+
+func TestSubmitWaitTimeout(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	release := make(chan struct{})
+	f := wp.Submit(func() (interface{}, error) {
+		<-release
+		return "done", nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := f.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("wait should stop on context deadline, got %v", err)
+	}
+	if f.Result() != nil {
+		t.Errorf("result should be empty before the task is done")
+	}
+	queued := wp.Submit(func() (interface{}, error) {
+		return "never", nil
+	})
+	wp.Finish()
+	close(release)
+
+	if res, err := f.Wait(context.Background()); res != "done" || err != nil {
+		t.Errorf("running task should complete: %v, %v", res, err)
+	}
+	if _, err := queued.Wait(context.Background()); err != ErrPoolFinished {
+		t.Errorf("queued task should fail on finish, got %v", err)
+	}
+	if _, err := wp.Submit(nil).Wait(context.Background()); err != ErrPoolFinished {
+		t.Errorf("submit to finished pool should fail, got %v", err)
+	}
+}
+
+func TestPool(t *testing.T) {
+	p := NewPool(3, func(ctx context.Context, n int) (string, error) {
+		if n == 0 {
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+		if n < 0 {
+			return "", errors.New("negative")
+		}
+		return strconv.Itoa(n * 2), nil
+	})
+	defer p.Finish()
+
+	f := p.Submit(21)
+	if res, err := f.Wait(context.Background()); res != "42" || err != nil {
+		t.Errorf("wrong typed result: %q, %v", res, err)
+	}
+	if _, err := p.Submit(-1).Wait(context.Background()); err == nil {
+		t.Errorf("typed future should return task error")
+	}
+	deadline := time.Now().Add(10 * time.Millisecond)
+	if _, err := p.SubmitWith(JobOptions{Deadline: deadline}, 0).Wait(context.Background()); err != context.DeadlineExceeded {
+		t.Errorf("typed task should get the task context, got %v", err)
+	}
+	if p.Size() != 3 {
+		t.Errorf("typed pool should expose worker pool size: %v", p.Size())
+	}
+}
+
+func TestSubmitCancel(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	defer wp.Finish()
+
+	started := make(chan struct{})
+	running := wp.SubmitContext(func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	var ran bool
+	queued := wp.Submit(func() (interface{}, error) {
+		ran = true
+		return nil, nil
+	})
+	<-started
+
+	if !queued.Cancel() {
+		t.Errorf("queued task should be removed by cancel")
+	}
+	if _, err := queued.Wait(context.Background()); err != context.Canceled {
+		t.Errorf("cancelled task should fail with context.Canceled, got %v", err)
+	}
+	if running.Cancel() {
+		t.Errorf("running task cannot be removed from the queue")
+	}
+	if _, err := running.Wait(context.Background()); err != context.Canceled {
+		t.Errorf("running task context should be cancelled, got %v", err)
+	}
+	if ran {
+		t.Errorf("cancelled task should not run")
+	}
+}
+
+func TestSubmitDeadline(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	defer wp.Finish()
+
+	release := blockPool(wp)
+	deadline := time.Now().Add(30 * time.Millisecond)
+	queued := wp.SubmitWith(JobOptions{Deadline: deadline}, func(ctx context.Context) (interface{}, error) {
+		return "late", nil
+	})
+	if _, err := queued.Wait(context.Background()); err != context.DeadlineExceeded {
+		t.Errorf("queued task should expire, got %v", err)
+	}
+	close(release)
+
+	running := wp.SubmitWith(JobOptions{Deadline: time.Now().Add(30 * time.Millisecond)}, func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if _, err := running.Wait(context.Background()); err != context.DeadlineExceeded {
+		t.Errorf("running task should see its deadline, got %v", err)
+	}
+}
+
+func TestFinishCancelInFlight(t *testing.T) {
+	wp := StartWorkerPool(2, nil, nil)
+	started := make(chan struct{}, 2)
+	task := func(ctx context.Context) (interface{}, error) {
+		started <- struct{}{}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			return "done", nil
+		}
+	}
+	first := wp.SubmitContext(task)
+	<-started
+	wp.Finish()
+	if res, _ := first.Wait(context.Background()); res != "done" {
+		t.Errorf("plain finish should let running tasks complete: %v", res)
+	}
+
+	wp = StartWorkerPool(2, nil, nil)
+	second := wp.SubmitContext(task)
+	<-started
+	wp.Finish(CancelInFlight)
+	if _, err := second.Wait(context.Background()); err != context.Canceled {
+		t.Errorf("running task should be cancelled, got %v", err)
+	}
+}
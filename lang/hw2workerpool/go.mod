@@ -1,3 +1,3 @@
 module github.com/adromaryn/mailru-go/lang/hw2workerpool
 
-go 1.17
+go 1.18
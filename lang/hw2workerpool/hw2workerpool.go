@@ -4,132 +4,357 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"time"
 )
 
 type WorkerPool struct {
-	bufChan chan struct{}
-	resync  chan struct{}
-	jobs    chan func(results chan interface{})
-	results chan interface{}
-	ctx     context.Context
-	finish  context.CancelFunc
-	lock    *sync.Mutex
-	counter int
-}
-
-func StartWorkerPool(count int, jobs chan func(results chan interface{}), results chan interface{}) *WorkerPool {
-	bufChan := make(chan struct{}, count)
-	resync := make(chan struct{})
+	jobs     chan func(results chan interface{})
+	results  chan interface{}
+	ctx      context.Context
+	finish   context.CancelFunc
+	jobCtx   context.Context
+	abort    context.CancelFunc
+	lock     *sync.Mutex
+	size     int
+	counter  int
+	slotFree chan struct{}
+	queue    taskQueue
+	// keys holds tasks waiting for an earlier task of the same key.
+	keys       map[string]*keyState
+	keyPending int
+	seq        uint64
+	aging      time.Duration
+	started    time.Time
+	// waitSum and waitCount are the cumulative queue wait of started jobs.
+	waitSum   time.Duration
+	waitCount int
+	queued    chan struct{}
+	closed    bool
+	// draining lets queued tasks start after Shutdown with DrainQueue.
+	draining bool
+	// notTaking is set once the dispatcher stops taking jobs from the channel.
+	notTaking bool
+	// abandoned counts jobs taken from the channel that never started.
+	abandoned int
+	changed   chan struct{}
+	// completed counts jobs that have returned, for shutdown reports.
+	completed int
+	failed    int
+	panics    int
+	onPanic   func(err *PanicError)
+
+	submitted   int
+	waitTime    timeHistogram
+	runTime     timeHistogram
+	resizeCount int
+	resizes     []ResizeEvent
+
+	limit      int
+	policy     OverflowPolicy
+	rejected   int
+	dropped    int
+	callerRuns int
+
+	journal *Journal
+}
+
+func StartWorkerPool(count int, jobs chan func(results chan interface{}), results chan interface{}, opts ...PoolOption) *WorkerPool {
 	ctx, finish := context.WithCancel(context.Background())
-	lock := &sync.Mutex{}
-	wp := &WorkerPool{bufChan, resync, jobs, results, ctx, finish, lock, 0}
-
-	go func(wp *WorkerPool) {
-		for {
-			wp.lock.Lock()
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
-			bufChan := wp.bufChan
-			resync := wp.resync
-			ctx := wp.ctx
-			select {
-			case bufChan <- struct{}{}:
-				select {
-				case j := <-jobs:
-					go func() {
-						wp.lock.Lock()
-						wp.counter = wp.counter + 1
-						wp.lock.Unlock()
-
-						defer func() {
-							wp.lock.Lock()
-							select {
-							case <-resync:
-								if wp.counter <= cap(wp.bufChan) {
-									<-wp.bufChan
-								}
-							default:
-								<-bufChan
-							}
-							wp.counter = wp.counter - 1
-							wp.lock.Unlock()
-						}()
-
-						j(wp.results)
-					}()
-				case <-ctx.Done():
-					wp.lock.Unlock()
-					return
-				default:
-					<-bufChan
+	jobCtx, abort := context.WithCancel(context.Background())
+	wp := &WorkerPool{
+		jobs:     jobs,
+		results:  results,
+		ctx:      ctx,
+		finish:   finish,
+		jobCtx:   jobCtx,
+		abort:    abort,
+		lock:     &sync.Mutex{},
+		size:     count,
+		slotFree: make(chan struct{}, 1),
+		queued:   make(chan struct{}, 1),
+		changed:  make(chan struct{}),
+		keys:     make(map[string]*keyState),
+		started:  time.Now(),
+		waitTime: newTimeHistogram(TimeBuckets),
+		runTime:  newTimeHistogram(TimeBuckets),
+	}
+	for _, opt := range opts {
+		opt(wp)
+	}
+	if wp.journal != nil {
+		wp.replay()
+	}
+	go wp.dispatch()
+	return wp
+}
+
+// dispatch sleeps until a worker is free and a job arrives, so an idle pool
+// costs nothing. A received job waits for a slot again in case the pool shrank.
+// Submitted tasks go first by priority, jobs from the channel are taken
+// when the queue is empty.
+func (wp *WorkerPool) dispatch() {
+	defer wp.failQueued(ErrPoolFinished)
+	defer wp.stopTaking()
+	for {
+		if !wp.waitSlot() {
+			return
+		}
+		if t := wp.dequeue(); t != nil {
+			if err := t.ctx.Err(); err != nil {
+				if wp.jobCtx.Err() == nil {
+					wp.forget(t)
 				}
-			case <-ctx.Done():
-				wp.lock.Unlock()
+				t.future.resolve(nil, err)
+				wp.keyDone(t)
+				wp.release(false)
+			} else {
+				wp.run(t.run)
+			}
+			continue
+		}
+		wp.lock.Lock()
+		jobs := wp.jobs
+		if wp.closed {
+			jobs = nil
+			wp.takingStopped()
+		}
+		wp.lock.Unlock()
+		select {
+		case j := <-jobs:
+			if !wp.start(j, time.Now()) {
 				return
-			default:
 			}
-			wp.lock.Unlock()
+		case <-wp.queued:
+		case <-wp.ctx.Done():
+			return
 		}
-	}(wp)
-
-	return wp
+	}
 }
 
-func (wp *WorkerPool) Finish() {
+// stopTaking tells Shutdown the dispatcher holds no job from the channel
+// and will not take more.
+func (wp *WorkerPool) stopTaking() {
 	wp.lock.Lock()
 	defer wp.lock.Unlock()
+	wp.takingStopped()
+}
+
+// takingStopped is stopTaking with the lock held.
+func (wp *WorkerPool) takingStopped() {
+	if !wp.notTaking {
+		wp.notTaking = true
+		wp.broadcast()
+	}
+}
+
+// waitSlot waits for a free worker, false means the pool is finished.
+func (wp *WorkerPool) waitSlot() bool {
+	for {
+		wp.lock.Lock()
+		free := wp.counter < wp.size
+		wp.lock.Unlock()
+		if wp.ctx.Err() != nil {
+			return false
+		}
+		if free {
+			return true
+		}
+		select {
+		case <-wp.slotFree:
+		case <-wp.ctx.Done():
+			return false
+		}
+	}
+}
+
+func (wp *WorkerPool) notify() {
+	select {
+	case wp.slotFree <- struct{}{}:
+	default:
+	}
+}
+
+// start takes a worker slot for j received from the channel and runs it.
+// If the pool stopped accepting jobs meanwhile, j is abandoned. It returns
+// false when the pool is finished.
+func (wp *WorkerPool) start(j func(results chan interface{}), received time.Time) bool {
+	wp.lock.Lock()
+	wp.submitted = wp.submitted + 1
+	wp.lock.Unlock()
+	finished := !wp.waitSlot()
+
+	wp.lock.Lock()
+	if finished || wp.closed || wp.ctx.Err() != nil {
+		wp.abandoned = wp.abandoned + 1
+		wp.lock.Unlock()
+		return !finished
+	}
+	wp.counter = wp.counter + 1
+	wp.observeWait(time.Since(received))
+	wp.lock.Unlock()
+	wp.run(j)
+	return true
+}
+
+// observeWait records the queue wait of a started job, the lock should be held.
+func (wp *WorkerPool) observeWait(d time.Duration) {
+	wp.waitSum = wp.waitSum + d
+	wp.waitCount = wp.waitCount + 1
+	wp.waitTime.observe(d)
+}
+
+// run runs j on a slot that is already taken, a panic of j is recovered
+// and reported instead of crashing the process.
+func (wp *WorkerPool) run(j func(results chan interface{})) {
+	go func() {
+		defer wp.release(true)
+		wp.call(j)
+	}()
+}
+
+// call runs j in the current goroutine, recovering its panic and recording
+// its run time.
+func (wp *WorkerPool) call(j func(results chan interface{})) {
+	defer wp.observeRun(time.Now())
+	defer func() {
+		if r := recover(); r != nil {
+			wp.panicked(recovered(r))
+		}
+	}()
+	j(wp.results)
+}
+
+func (wp *WorkerPool) release(completed bool) {
+	wp.lock.Lock()
+	wp.counter = wp.counter - 1
+	if completed {
+		wp.completed = wp.completed + 1
+	}
+	wp.broadcast()
+	wp.lock.Unlock()
+	wp.notify()
+	wp.closeJournal()
+}
+
+// broadcast wakes everyone waiting for a change of active or queued jobs,
+// the lock should be held.
+func (wp *WorkerPool) broadcast() {
+	close(wp.changed)
+	wp.changed = make(chan struct{})
+}
+
+type FinishOption int
+
+const (
+	// CancelInFlight cancels contexts of running jobs.
+	CancelInFlight FinishOption = iota + 1
+	// DrainQueue makes Shutdown run queued tasks instead of abandoning them.
+	DrainQueue
+)
+
+func hasOption(opts []FinishOption, opt FinishOption) bool {
+	for _, o := range opts {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// Finish stops dispatching, queued tasks fail with ErrPoolFinished.
+// Running jobs are left to complete unless CancelInFlight is passed.
+func (wp *WorkerPool) Finish(opts ...FinishOption) {
 	wp.finish()
-	close(wp.bufChan)
+	if hasOption(opts, CancelInFlight) {
+		wp.abort()
+	}
+	wp.closeJournal()
+}
+
+type ShutdownReport struct {
+	// Completed jobs have returned while the pool was shutting down.
+	Completed int
+	// Abandoned tasks were queued and will never run.
+	Abandoned int
+	// Running jobs had not returned when the context expired.
+	Running int
+}
+
+// Shutdown stops accepting jobs and waits for running jobs, and queued tasks
+// with DrainQueue, to finish. Jobs left in the jobs channel are not taken,
+// a job taken before and not started yet is counted as abandoned.
+// If ctx expires first, ctx error is returned and running jobs are left alone,
+// or cancelled with CancelInFlight.
+func (wp *WorkerPool) Shutdown(ctx context.Context, opts ...FinishOption) (ShutdownReport, error) {
+	report := ShutdownReport{}
+	wp.lock.Lock()
+	wp.closed = true
+	wp.draining = hasOption(opts, DrainQueue)
+	wp.broadcast()
+	completed := wp.completed
+	abandoned := wp.abandoned
+	wp.lock.Unlock()
+	select {
+	case wp.queued <- struct{}{}:
+	default:
+	}
+	if !hasOption(opts, DrainQueue) {
+		report.Abandoned = wp.failQueued(ErrPoolFinished)
+	}
+
+	var err error
+	for err == nil {
+		wp.lock.Lock()
+		idle := wp.counter == 0 && wp.queuedLocked() == 0 && wp.notTaking
+		changed := wp.changed
+		wp.lock.Unlock()
+		if idle {
+			break
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	report.Abandoned += wp.failQueued(ErrPoolFinished)
+	wp.finish()
+	if err != nil && hasOption(opts, CancelInFlight) {
+		wp.abort()
+	}
+	wp.closeJournal()
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+	report.Completed = wp.completed - completed
+	report.Abandoned += wp.abandoned - abandoned
+	report.Running = wp.counter
+	return report, err
 }
 
 func (wp *WorkerPool) AddWorkers(count int) {
 	wp.lock.Lock()
 	defer wp.lock.Unlock()
-	currentCap := cap(wp.bufChan)
-	currentLen := len(wp.bufChan)
-	newBufChan := make(chan struct{}, currentCap+count)
-	close(wp.bufChan)
-	wp.bufChan = newBufChan
-	for i := 0; i < currentLen; i++ {
-		newBufChan <- struct{}{}
-	}
+	wp.size = wp.size + count
+	wp.resized(wp.size - count)
+	wp.notify()
 }
 
 func (wp *WorkerPool) DecWorkers(count int) error {
 	wp.lock.Lock()
 	defer wp.lock.Unlock()
-	currentCap := cap(wp.bufChan)
-	if currentCap <= count {
+	if wp.size <= count {
 		return errors.New("tried to stop all workers")
 	}
-	currentLen := len(wp.bufChan)
-	currentChan := wp.bufChan
-	newBufChan := make(chan struct{}, currentCap-count)
-	wp.bufChan = newBufChan
-	if currentLen < currentCap-count {
-		for i := 0; i < currentLen; i++ {
-			newBufChan <- struct{}{}
-		}
-	} else {
-		for i := 0; i < currentCap-count; i++ {
-			newBufChan <- struct{}{}
-		}
-	}
-	close(wp.resync)
-	close(currentChan)
-	wp.resync = make(chan struct{})
-
+	wp.size = wp.size - count
+	wp.resized(wp.size + count)
 	return nil
 }
 
 func (wp *WorkerPool) Size() int {
 	wp.lock.Lock()
 	defer wp.lock.Unlock()
-	count := cap(wp.bufChan)
-	return count
+	return wp.size
 }
 
 func (wp *WorkerPool) ActiveCount() int {
@@ -0,0 +1,399 @@
+package hw2workerpool
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+var ErrNoJournal = errors.New("worker pool has no journal")
+
+// ErrDiscard is wrapped by a handler error to acknowledge a job that failed
+// for good. A job failing with another error or a panic is replayed.
+var ErrDiscard = errors.New("durable job discarded")
+
+// JobHandler runs a durable job of a registered type with its payload.
+type JobHandler func(ctx context.Context, payload []byte) (interface{}, error)
+
+// Registry resolves durable job type names to their handlers.
+type Registry struct {
+	mu       sync.Mutex
+	handlers map[string]JobHandler
+}
+
+func NewRegistry() *Registry {
+	return &Registry{handlers: make(map[string]JobHandler)}
+}
+
+func (r *Registry) Register(name string, h JobHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[name] = h
+}
+
+func (r *Registry) lookup(name string) (JobHandler, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.handlers[name]
+	return h, ok
+}
+
+// DefaultMaxPanics is the MaxPanics of a newly opened journal.
+const DefaultMaxPanics = 3
+
+// compactAfter is the least number of dead records that makes the journal
+// rewrite its file while running, it is rewritten once they outnumber jobs.
+const compactAfter = 128
+
+// Journal is an append-only file of durable jobs. A job is written before it
+// is queued and acknowledged after its handler succeeds, so jobs are run at
+// least once: the ones not acknowledged are replayed by the next pool.
+// Jobs cancelled or expired before they finish are acknowledged as well.
+type Journal struct {
+	// MaxPanics acknowledges a job once its handler has panicked that many
+	// times, so a poison job is not replayed forever. 0 means no limit.
+	MaxPanics int
+
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	registry *Registry
+	nextID   uint64
+	pending  map[uint64]journalRecord
+	// dead counts records of the file that are of no use anymore.
+	dead int
+}
+
+type journalRecord struct {
+	ID       uint64 `json:"id"`
+	Type     string `json:"type,omitempty"`
+	Payload  []byte `json:"payload,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	Key      string `json:"key,omitempty"`
+	Ack      bool   `json:"ack,omitempty"`
+	// Panics counts panics of the job handler, a record with the ID only
+	// updates the count of its job.
+	Panics int `json:"panics,omitempty"`
+}
+
+// OpenJournal loads jobs not acknowledged yet, every one of them should have
+// a registered type. The file is rewritten with these jobs only, which drops
+// acknowledged jobs and a torn record left by a crash at the end of the file.
+func OpenJournal(path string, registry *Registry) (*Journal, error) {
+	j := &Journal{MaxPanics: DefaultMaxPanics, path: path, registry: registry, nextID: 1, pending: make(map[uint64]journalRecord)}
+	if err := j.load(path); err != nil {
+		return nil, err
+	}
+	for _, rec := range j.pending {
+		if _, ok := registry.lookup(rec.Type); !ok {
+			return nil, fmt.Errorf("journal %s: unknown job type %q", path, rec.Type)
+		}
+	}
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *Journal) load(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var offset int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rec := journalRecord{}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("journal %s: broken record at offset %d: %v", path, offset, err)
+		}
+		switch {
+		case rec.Ack:
+			delete(j.pending, rec.ID)
+		case rec.Type == "" && rec.Panics > 0:
+			if job, ok := j.pending[rec.ID]; ok {
+				job.Panics = rec.Panics
+				j.pending[rec.ID] = job
+			}
+		default:
+			j.pending[rec.ID] = rec
+		}
+		if rec.ID >= j.nextID {
+			j.nextID = rec.ID + 1
+		}
+		offset += int64(len(line))
+	}
+}
+
+// compact writes pending jobs to a new file and renames it over the journal,
+// then the new file is opened for appending. The lock should be held
+// once the journal is open.
+func (j *Journal) compact() error {
+	tmp := j.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for _, rec := range j.unackedLocked() {
+		if err := writeRecord(writer, rec); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(j.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	// the old file is gone, appending to it would lose records
+	if j.file != nil {
+		j.file.Close()
+	}
+	j.file, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0644)
+	j.dead = 0
+	return err
+}
+
+func writeRecord(w io.Writer, rec journalRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// append writes a record and syncs it to disk, the lock should be held.
+func (j *Journal) append(rec journalRecord) error {
+	if j.file == nil {
+		return os.ErrClosed
+	}
+	if err := writeRecord(j.file, rec); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+func (j *Journal) add(name string, payload []byte, opts JobOptions) (journalRecord, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	rec := journalRecord{ID: j.nextID, Type: name, Payload: payload, Priority: opts.Priority, Key: opts.Key}
+	if err := j.append(rec); err != nil {
+		return rec, err
+	}
+	j.nextID = j.nextID + 1
+	j.pending[rec.ID] = rec
+	return rec, nil
+}
+
+// ack marks the job done, if it cannot be written the job is run again
+// after restart.
+func (j *Journal) ack(id uint64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.ackLocked(id)
+}
+
+func (j *Journal) ackLocked(id uint64) {
+	job, ok := j.pending[id]
+	if !ok || j.append(journalRecord{ID: id, Ack: true}) != nil {
+		return
+	}
+	delete(j.pending, id)
+	// the job, its panic counts and the ack itself
+	j.dead += 2 + job.Panics
+	if j.dead >= compactAfter && j.dead > len(j.pending) {
+		// a failed compaction leaves the file as it was
+		j.compact()
+	}
+}
+
+// panicked counts a panic of the job handler and acknowledges the job
+// once it reaches MaxPanics.
+func (j *Journal) panicked(id uint64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.pending[id]
+	if !ok {
+		return
+	}
+	job.Panics = job.Panics + 1
+	if j.append(journalRecord{ID: id, Panics: job.Panics}) != nil {
+		return
+	}
+	j.pending[id] = job
+	if j.MaxPanics > 0 && job.Panics >= j.MaxPanics {
+		j.ackLocked(id)
+	}
+}
+
+// unacked returns jobs not acknowledged yet in submission order.
+func (j *Journal) unacked() []journalRecord {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.unackedLocked()
+}
+
+func (j *Journal) unackedLocked() []journalRecord {
+	records := make([]journalRecord, 0, len(j.pending))
+	for _, rec := range j.pending {
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(a, b int) bool {
+		return records[a].ID < records[b].ID
+	})
+	return records
+}
+
+// Len counts jobs not acknowledged yet.
+func (j *Journal) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.pending)
+}
+
+// Close closes the file, later acknowledgements are lost and their jobs are
+// replayed. The pool closes its journal once it is finished and idle.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+type PoolOption func(wp *WorkerPool)
+
+// WithJournal makes the pool replay unfinished jobs of j on start
+// and enables SubmitDurable. The pool closes j when it is finished.
+func WithJournal(j *Journal) PoolOption {
+	return func(wp *WorkerPool) {
+		wp.journal = j
+	}
+}
+
+// SubmitDurable stores a job of a registered type in the journal and queues it.
+// Only the priority and the key of opts are stored for replay. A job refused
+// by the queue limit or by a finished pool fails with ErrQueueFull or
+// ErrPoolFinished and is not kept in the journal.
+func (wp *WorkerPool) SubmitDurable(name string, payload []byte, opts JobOptions) (*Future, error) {
+	if wp.journal == nil {
+		return nil, ErrNoJournal
+	}
+	h, ok := wp.journal.registry.lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown job type %q", name)
+	}
+	if err := wp.admit(); err != nil {
+		return nil, err
+	}
+	rec, err := wp.journal.add(name, payload, opts)
+	if err != nil {
+		return nil, err
+	}
+	// the pool may have changed since admit, the refused job is acknowledged
+	future := wp.submitJournaled(rec, h, opts, true)
+	if err := future.Err(); err == ErrQueueFull || err == ErrPoolFinished {
+		return nil, err
+	}
+	return future, nil
+}
+
+// admit tells whether a new task would be refused right away.
+func (wp *WorkerPool) admit() error {
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+	if wp.closed || wp.ctx.Err() != nil {
+		return ErrPoolFinished
+	}
+	if wp.limit > 0 && wp.queuedLocked() >= wp.limit && wp.policy == OverflowReject {
+		return ErrQueueFull
+	}
+	return nil
+}
+
+// submitJournaled queues a journaled job, it is acknowledged once it succeeds,
+// is discarded, or its own context is cancelled or expires. A pool finished
+// with CancelInFlight leaves its jobs for replay.
+func (wp *WorkerPool) submitJournaled(rec journalRecord, h JobHandler, opts JobOptions, limited bool) *Future {
+	return wp.submit(opts, func(ctx context.Context) (interface{}, error) {
+		defer func() {
+			if r := recover(); r != nil {
+				wp.journal.panicked(rec.ID)
+				panic(r)
+			}
+		}()
+		res, err := h(ctx, rec.Payload)
+		if err == nil || errors.Is(err, ErrDiscard) || (ctx.Err() != nil && wp.jobCtx.Err() == nil) {
+			wp.journal.ack(rec.ID)
+		}
+		return res, err
+	}, rec.ID, limited)
+}
+
+// replay queues unfinished jobs of the journal, the queue limit does not
+// apply to them.
+func (wp *WorkerPool) replay() {
+	for _, rec := range wp.journal.unacked() {
+		h, _ := wp.journal.registry.lookup(rec.Type)
+		wp.submitJournaled(rec, h, JobOptions{Priority: rec.Priority, Key: rec.Key}, false)
+	}
+}
+
+// forget acknowledges a durable task that will never run, since it was
+// refused, dropped, cancelled or expired in the queue.
+func (wp *WorkerPool) forget(t *task) {
+	if t.durable != 0 {
+		wp.journal.ack(t.durable)
+	}
+}
+
+// closeJournal closes the journal once the pool is finished and no job
+// is running.
+func (wp *WorkerPool) closeJournal() {
+	if wp.journal == nil {
+		return
+	}
+	wp.lock.Lock()
+	idle := wp.counter == 0 && wp.ctx.Err() != nil
+	wp.lock.Unlock()
+	if idle {
+		wp.journal.Close()
+	}
+}
@@ -0,0 +1,343 @@
+package hw2workerpool
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type journalRun struct {
+	mu   sync.Mutex
+	seen []string
+}
+
+func (r *journalRun) registry() *Registry {
+	registry := NewRegistry()
+	registry.Register("echo", func(ctx context.Context, payload []byte) (interface{}, error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.seen = append(r.seen, string(payload))
+		return string(payload), nil
+	})
+	return registry
+}
+
+func TestJournalReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.log")
+	run := &journalRun{}
+	journal, err := OpenJournal(path, run.registry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wp := StartWorkerPool(1, nil, nil, WithJournal(journal))
+	release := blockPool(wp)
+	first, err := wp.SubmitDurable("echo", []byte("first"), JobOptions{Priority: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wp.SubmitDurable("echo", []byte("second"), JobOptions{Key: "k"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wp.SubmitDurable("echo", []byte("third"), JobOptions{Key: "k"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wp.SubmitDurable("missing", nil, JobOptions{}); err == nil {
+		t.Errorf("unregistered job type should be refused")
+	}
+	// the pool goes down with jobs still queued
+	wp.Finish()
+	close(release)
+	if _, err := first.Wait(context.Background()); err != ErrPoolFinished {
+		t.Errorf("queued job should be abandoned, got %v", err)
+	}
+	if err := journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a torn record of a crashed write is cut off
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"id":9,"type":"ec`); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	run = &journalRun{}
+	journal, err = OpenJournal(path, run.registry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if journal.Len() != 3 {
+		t.Errorf("unfinished jobs should be loaded: %v", journal.Len())
+	}
+	wp = StartWorkerPool(1, nil, nil, WithJournal(journal))
+	if _, err := wp.Shutdown(context.Background(), DrainQueue); err != nil {
+		t.Fatal(err)
+	}
+	if len(run.seen) != 3 || run.seen[0] != "first" || run.seen[1] != "second" || run.seen[2] != "third" {
+		t.Errorf("jobs should be replayed in order: %v", run.seen)
+	}
+	if journal.Len() != 0 {
+		t.Errorf("replayed jobs should be acknowledged: %v", journal.Len())
+	}
+	if _, err := journal.add("echo", nil, JobOptions{}); err == nil {
+		t.Errorf("journal should be closed by Shutdown")
+	}
+
+	journal, err = OpenJournal(path, run.registry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	if journal.Len() != 0 {
+		t.Errorf("acknowledgements should be persisted: %v", journal.Len())
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("acknowledged jobs should be compacted away, %d bytes left", info.Size())
+	}
+	wp = StartWorkerPool(1, nil, nil, WithJournal(journal))
+	f2, err := wp.SubmitDurable("echo", []byte("next"), JobOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := f2.Wait(context.Background()); err != nil || res != "next" {
+		t.Errorf("durable job should run: %v, %v", res, err)
+	}
+	if _, err := wp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJournalAck(t *testing.T) {
+	started := make(chan struct{})
+	registry := NewRegistry()
+	registry.Register("job", func(ctx context.Context, payload []byte) (interface{}, error) {
+		switch string(payload) {
+		case "fail":
+			return nil, errors.New("failed")
+		case "discard":
+			return nil, fmt.Errorf("bad payload: %w", ErrDiscard)
+		case "panic":
+			panic("job")
+		case "wait":
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return nil, nil
+	})
+	path := filepath.Join(t.TempDir(), "jobs.log")
+	journal, err := OpenJournal(path, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wp := StartWorkerPool(1, nil, nil, WithJournal(journal))
+	for _, payload := range []string{"ok", "fail", "discard", "panic"} {
+		future, err := wp.SubmitDurable("job", []byte(payload), JobOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-future.Done()
+	}
+	waiting, err := wp.SubmitDurable("job", []byte("wait"), JobOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	wp.Finish(CancelInFlight)
+	<-waiting.Done()
+	if journal.Len() != 3 {
+		t.Errorf("failed, panicked and interrupted jobs should stay in the journal: %v", journal.Len())
+	}
+	if _, err := wp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	journal, err = OpenJournal(path, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	var left []string
+	for _, rec := range journal.unacked() {
+		left = append(left, string(rec.Payload))
+	}
+	if fmt.Sprint(left) != "[fail panic wait]" {
+		t.Errorf("unexpected jobs left: %v", left)
+	}
+}
+
+func TestJournalOverflow(t *testing.T) {
+	run := &journalRun{}
+	journal, err := OpenJournal(filepath.Join(t.TempDir(), "jobs.log"), run.registry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	wp := StartWorkerPool(1, nil, nil, WithJournal(journal))
+	release := blockPool(wp)
+	wp.SetQueueLimit(1, OverflowReject)
+	oldest, err := wp.SubmitDurable("echo", []byte("oldest"), JobOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wp.SubmitDurable("echo", []byte("rejected"), JobOptions{}); err != ErrQueueFull || journal.Len() != 1 {
+		t.Errorf("rejected job should not be journaled: %v, %d", err, journal.Len())
+	}
+	wp.SetQueueLimit(1, OverflowDropOldest)
+	if _, err := wp.SubmitDurable("echo", []byte("newest"), JobOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if oldest.Err() != ErrQueueFull || journal.Len() != 1 {
+		t.Errorf("dropped job should be acknowledged: %v, %d", oldest.Err(), journal.Len())
+	}
+	close(release)
+	if _, err := wp.Shutdown(context.Background(), DrainQueue); err != nil {
+		t.Fatal(err)
+	}
+	if len(run.seen) != 1 || run.seen[0] != "newest" || journal.Len() != 0 {
+		t.Errorf("only the newest job should run: %v, %d", run.seen, journal.Len())
+	}
+}
+
+func TestJournalCancel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.log")
+	run := &journalRun{}
+	journal, err := OpenJournal(path, run.registry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wp := StartWorkerPool(1, nil, nil, WithJournal(journal))
+	release := blockPool(wp)
+	cancelled, err := wp.SubmitDurable("echo", []byte("cancelled"), JobOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := wp.SubmitDurable("echo", []byte("expired"), JobOptions{Deadline: time.Now().Add(time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cancelled.Cancel() {
+		t.Errorf("queued job should be cancelled")
+	}
+	if _, err := expired.Wait(context.Background()); err != context.DeadlineExceeded {
+		t.Errorf("queued job should expire: %v", err)
+	}
+	close(release)
+	if _, err := wp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wp.SubmitDurable("echo", nil, JobOptions{}); err != ErrPoolFinished {
+		t.Errorf("finished pool should refuse durable jobs: %v", err)
+	}
+
+	journal, err = OpenJournal(path, run.registry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	if journal.Len() != 0 || len(run.seen) != 0 {
+		t.Errorf("cancelled and expired jobs should be acknowledged: %d, %v", journal.Len(), run.seen)
+	}
+}
+
+func TestJournalPanicLimit(t *testing.T) {
+	var calls int32
+	registry := NewRegistry()
+	registry.Register("poison", func(ctx context.Context, payload []byte) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		panic("poison")
+	})
+	path := filepath.Join(t.TempDir(), "jobs.log")
+	for i := 0; i < DefaultMaxPanics+1; i++ {
+		journal, err := OpenJournal(path, registry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wp := StartWorkerPool(1, nil, nil, WithJournal(journal))
+		if i == 0 {
+			if _, err := wp.SubmitDurable("poison", nil, JobOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := wp.Shutdown(context.Background(), DrainQueue); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != DefaultMaxPanics {
+		t.Errorf("panicking job should be replayed until the limit: %v", calls)
+	}
+}
+
+func TestJournalCompactWhileRunning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.log")
+	run := &journalRun{}
+	journal, err := OpenJournal(path, run.registry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wp := StartWorkerPool(1, nil, nil, WithJournal(journal))
+	for i := 0; i < compactAfter; i++ {
+		future, err := wp.SubmitDurable("echo", []byte("job"), JobOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-future.Done()
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines >= compactAfter {
+		t.Errorf("acknowledged jobs should be compacted away while running, %d lines left", lines)
+	}
+	if _, err := wp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJournalErrors(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	defer wp.Finish()
+	if _, err := wp.SubmitDurable("echo", nil, JobOptions{}); err != ErrNoJournal {
+		t.Errorf("pool without journal should refuse durable jobs, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jobs.log")
+	if err := os.WriteFile(path, []byte("{\"id\":1,\"type\":\"gone\"}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenJournal(path, NewRegistry()); err == nil {
+		t.Errorf("journal with unknown job types should not open")
+	}
+	if err := os.WriteFile(path, []byte("{\"id\":1,\"type\":\"gone\"}\n{\"id\":1,\"ack\":true}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	journal, err := OpenJournal(path, NewRegistry())
+	if err != nil {
+		t.Errorf("acknowledged jobs of unknown types should be ignored: %v", err)
+	} else {
+		journal.Close()
+	}
+	if err := os.WriteFile(path, []byte("garbage\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenJournal(path, NewRegistry()); err == nil {
+		t.Errorf("broken journal should not open")
+	}
+}
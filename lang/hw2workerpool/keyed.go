@@ -0,0 +1,67 @@
+package hw2workerpool
+
+// keyState tracks a key with a task queued or running, later tasks
+// of the key wait in pending and are queued one by one.
+type keyState struct {
+	pending []*task
+}
+
+// keyBusy parks t behind the active task of its key, or marks the key
+// active if there is none. The lock should be held.
+func (wp *WorkerPool) keyBusy(t *task) bool {
+	ks, ok := wp.keys[t.key]
+	if !ok {
+		wp.keys[t.key] = &keyState{}
+		return false
+	}
+	ks.pending = append(ks.pending, t)
+	wp.keyPending = wp.keyPending + 1
+	return true
+}
+
+// keyDone queues the next task of the key after t has finished or left the queue.
+func (wp *WorkerPool) keyDone(t *task) {
+	if t.key == "" {
+		return
+	}
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+	ks, ok := wp.keys[t.key]
+	if !ok {
+		return
+	}
+	if len(ks.pending) == 0 {
+		delete(wp.keys, t.key)
+		return
+	}
+	next := ks.pending[0]
+	ks.pending[0] = nil
+	ks.pending = ks.pending[1:]
+	wp.keyPending = wp.keyPending - 1
+	wp.push(next)
+	select {
+	case wp.queued <- struct{}{}:
+	default:
+	}
+}
+
+// removePending removes t waiting behind its key, the lock should be held.
+func (wp *WorkerPool) removePending(t *task) bool {
+	ks, ok := wp.keys[t.key]
+	if !ok {
+		return false
+	}
+	for i, pending := range ks.pending {
+		if pending == t {
+			ks.pending = append(ks.pending[:i], ks.pending[i+1:]...)
+			wp.keyPending = wp.keyPending - 1
+			return true
+		}
+	}
+	return false
+}
+
+// queuedLocked counts tasks that have not started, the lock should be held.
+func (wp *WorkerPool) queuedLocked() int {
+	return len(wp.queue) + wp.keyPending
+}
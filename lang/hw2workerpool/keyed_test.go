@@ -0,0 +1,107 @@
+package hw2workerpool
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyedOrder(t *testing.T) {
+	wp := StartWorkerPool(4, nil, nil)
+	mu := &sync.Mutex{}
+	running := map[string]bool{}
+	order := map[string][]int{}
+	maxParallel, parallel := 0, 0
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("account%d", i%3)
+		i := i
+		wp.SubmitWith(JobOptions{Key: key}, func(context.Context) (interface{}, error) {
+			mu.Lock()
+			if running[key] {
+				t.Errorf("tasks of %s run concurrently", key)
+			}
+			running[key] = true
+			parallel++
+			if parallel > maxParallel {
+				maxParallel = parallel
+			}
+			order[key] = append(order[key], i)
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			running[key] = false
+			parallel--
+			mu.Unlock()
+			return nil, nil
+		})
+	}
+	wp.Shutdown(context.Background(), DrainQueue)
+
+	for key, items := range order {
+		if len(items) != 10 {
+			t.Errorf("all tasks of %s should run: %v", key, items)
+		}
+		for j := 1; j < len(items); j++ {
+			if items[j] < items[j-1] {
+				t.Errorf("tasks of %s run out of order: %v", key, items)
+				break
+			}
+		}
+	}
+	if maxParallel < 2 {
+		t.Errorf("different keys should run concurrently")
+	}
+}
+
+func TestKeyedDoesNotBlockWorkers(t *testing.T) {
+	wp := StartWorkerPool(2, nil, nil)
+	defer wp.Finish()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	wp.SubmitWith(JobOptions{Key: "busy"}, func(context.Context) (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+	next := wp.SubmitWith(JobOptions{Key: "busy"}, func(context.Context) (interface{}, error) {
+		return "next", nil
+	})
+	other := wp.SubmitWith(JobOptions{Key: "other"}, func(context.Context) (interface{}, error) {
+		return "other", nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if res, err := other.Wait(ctx); res != "other" || err != nil {
+		t.Errorf("free worker should take other keys while one is busy: %v, %v", res, err)
+	}
+	if next.Result() != nil {
+		t.Errorf("task should wait for the earlier task of its key")
+	}
+	close(release)
+	if res, _ := next.Wait(ctx); res != "next" {
+		t.Errorf("task should run after the earlier task of its key: %v", res)
+	}
+}
+
+func TestKeyedCancel(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	release := blockPool(wp)
+	rec := &orderRecorder{}
+	first := wp.SubmitWith(JobOptions{Key: "k"}, rec.task("first"))
+	second := wp.SubmitWith(JobOptions{Key: "k"}, rec.task("second"))
+	wp.SubmitWith(JobOptions{Key: "k"}, rec.task("third"))
+	if !second.Cancel() || !first.Cancel() {
+		t.Errorf("queued keyed tasks should be cancelled")
+	}
+	close(release)
+	wp.Shutdown(context.Background(), DrainQueue)
+	if len(rec.order) != 1 || rec.order[0] != "third" {
+		t.Errorf("cancelling tasks should not stall their key: %v", rec.order)
+	}
+}
@@ -0,0 +1,105 @@
+package hw2workerpool
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrQueueFull = errors.New("worker pool queue is full")
+
+// OverflowPolicy decides what Submit does when the queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes Submit wait for space in the queue, the wait ends
+	// with an error when the task is cancelled or reaches its deadline.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowReject fails the new task with ErrQueueFull.
+	OverflowReject
+	// OverflowDropOldest fails the longest queued task with ErrQueueFull.
+	OverflowDropOldest
+	// OverflowCallerRuns runs the new task in the goroutine calling Submit,
+	// ignoring its key order. Its panic is recovered as on a worker.
+	OverflowCallerRuns
+)
+
+// SetQueueLimit bounds the number of submitted tasks waiting for a worker,
+// 0 means unbounded. Jobs sent to the jobs channel are not affected.
+func (wp *WorkerPool) SetQueueLimit(limit int, policy OverflowPolicy) {
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+	wp.limit = limit
+	wp.policy = policy
+	wp.broadcast()
+}
+
+// overflow handles a task submitted to the full queue, it returns false when
+// the task is done with. The lock should be held, it is released meanwhile.
+func (wp *WorkerPool) overflow(t *task, f func(ctx context.Context) (interface{}, error)) bool {
+	switch wp.policy {
+	case OverflowReject:
+		wp.rejected = wp.rejected + 1
+		wp.lock.Unlock()
+		t.cancel()
+		wp.forget(t)
+		t.future.resolve(nil, ErrQueueFull)
+		wp.lock.Lock()
+		return false
+	case OverflowDropOldest:
+		oldest := wp.oldestLocked()
+		wp.lock.Unlock()
+		if oldest != nil && wp.unqueue(oldest, ErrQueueFull) {
+			wp.lock.Lock()
+			wp.dropped = wp.dropped + 1
+		} else {
+			wp.lock.Lock()
+		}
+		return true
+	case OverflowCallerRuns:
+		wp.callerRuns = wp.callerRuns + 1
+		wp.submitted = wp.submitted + 1
+		wp.lock.Unlock()
+		wp.call(func(chan interface{}) {
+			wp.execute(t, f)
+		})
+		wp.lock.Lock()
+		wp.completed = wp.completed + 1
+		return false
+	}
+	changed := wp.changed
+	wp.lock.Unlock()
+	select {
+	case <-changed:
+	case <-wp.ctx.Done():
+	case <-t.ctx.Done():
+	}
+	wp.lock.Lock()
+	if err := t.ctx.Err(); err != nil && !wp.closed && wp.ctx.Err() == nil {
+		wp.lock.Unlock()
+		wp.forget(t)
+		t.future.resolve(nil, err)
+		wp.lock.Lock()
+		return false
+	}
+	return true
+}
+
+// oldestLocked finds the earliest submitted task that has not started,
+// the lock should be held.
+func (wp *WorkerPool) oldestLocked() *task {
+	var oldest *task
+	check := func(t *task) {
+		if oldest == nil || t.seq < oldest.seq {
+			oldest = t
+		}
+	}
+	for _, t := range wp.queue {
+		check(t)
+	}
+	for _, ks := range wp.keys {
+		if len(ks.pending) > 0 {
+			check(ks.pending[0])
+		}
+	}
+	return oldest
+}
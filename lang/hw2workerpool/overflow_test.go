@@ -0,0 +1,139 @@
+package hw2workerpool
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOverflowReject(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	defer wp.Finish()
+	release := blockPool(wp)
+	defer close(release)
+	wp.SetQueueLimit(2, OverflowReject)
+
+	wp.Submit(sleepTask(0))
+	wp.Submit(sleepTask(0))
+	if _, err := wp.Submit(sleepTask(0)).Wait(context.Background()); err != ErrQueueFull {
+		t.Errorf("task over the limit should be rejected, got %v", err)
+	}
+	if snap := wp.Snapshot(); snap.Rejected != 1 || snap.Queued != 2 {
+		t.Errorf("wrong overflow stats: %+v", snap)
+	}
+	b := &bytes.Buffer{}
+	wp.Snapshot().WritePrometheus(b)
+	if !strings.Contains(b.String(), "workerpool_jobs_rejected_total 1\n") {
+		t.Errorf("rejected tasks should be exported:\n%s", b)
+	}
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	release := blockPool(wp)
+	wp.SetQueueLimit(2, OverflowDropOldest)
+	rec := &orderRecorder{}
+
+	first := wp.SubmitWith(JobOptions{Priority: 1}, rec.task("first"))
+	wp.SubmitWith(JobOptions{}, rec.task("second"))
+	wp.SubmitWith(JobOptions{}, rec.task("third"))
+	if _, err := first.Wait(context.Background()); err != ErrQueueFull {
+		t.Errorf("oldest task should be dropped, got %v", err)
+	}
+	close(release)
+	wp.Shutdown(context.Background(), DrainQueue)
+	if len(rec.order) != 2 || rec.order[0] != "second" || rec.order[1] != "third" {
+		t.Errorf("newer tasks should run: %v", rec.order)
+	}
+	if wp.Snapshot().Dropped != 1 {
+		t.Errorf("dropped task should be counted")
+	}
+}
+
+func TestOverflowCallerRuns(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	defer wp.Finish()
+	release := blockPool(wp)
+	defer close(release)
+	wp.SetQueueLimit(1, OverflowCallerRuns)
+
+	wp.Submit(sleepTask(0))
+	ran := false
+	f := wp.Submit(func() (interface{}, error) {
+		ran = true
+		return "caller", nil
+	})
+	if !ran || f.Result() != "caller" {
+		t.Errorf("task should run in the caller goroutine")
+	}
+	if wp.Snapshot().CallerRuns != 1 {
+		t.Errorf("caller runs should be counted")
+	}
+
+	var reported *PanicError
+	wp.SetPanicHandler(func(err *PanicError) {
+		reported = err
+	})
+	f = wp.Submit(func() (interface{}, error) {
+		panic("inline")
+	})
+	_, err := f.Wait(context.Background())
+	if perr, ok := err.(*PanicError); !ok || perr.Value != "inline" || perr != reported {
+		t.Errorf("caller run panic should be recovered and reported, got %v", err)
+	}
+	snap := wp.Snapshot()
+	if snap.Submitted != 4 || snap.Completed != 2 || snap.Panics != 1 || snap.RunTime.Count != 2 {
+		t.Errorf("caller runs should be counted as jobs: %+v", snap)
+	}
+}
+
+func TestOverflowBlockDeadline(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	defer wp.Finish()
+	release := blockPool(wp)
+	defer close(release)
+	wp.SetQueueLimit(1, OverflowBlock)
+	wp.Submit(sleepTask(0))
+
+	f := wp.SubmitWith(JobOptions{Deadline: time.Now().Add(20 * time.Millisecond)}, func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	if _, err := f.Wait(context.Background()); err != context.DeadlineExceeded {
+		t.Errorf("blocked submit should end at the task deadline, got %v", err)
+	}
+}
+
+func TestOverflowBlock(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	release := blockPool(wp)
+	wp.SetQueueLimit(1, OverflowBlock)
+	wp.Submit(sleepTask(0))
+
+	submitted := make(chan *Future)
+	go func() {
+		submitted <- wp.Submit(sleepTask(0))
+	}()
+	select {
+	case <-submitted:
+		t.Fatalf("submit should block while the queue is full")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	f := <-submitted
+	if _, err := f.Wait(context.Background()); err != nil {
+		t.Errorf("blocked task should run after space is freed: %v", err)
+	}
+
+	release = blockPool(wp)
+	wp.Submit(sleepTask(0))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		wp.Finish()
+	}()
+	if _, err := wp.Submit(sleepTask(0)).Wait(context.Background()); err != ErrPoolFinished {
+		t.Errorf("blocked submit should fail when the pool finishes, got %v", err)
+	}
+	close(release)
+}
@@ -0,0 +1,51 @@
+package hw2workerpool
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is a recovered panic of a job, Stack is the trace
+// of the panicking goroutine.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// recovered makes a PanicError of a recovered value, it should be called
+// by the deferred function so the stack still has the panicking frames.
+func recovered(r interface{}) *PanicError {
+	if err, ok := r.(*PanicError); ok {
+		return err
+	}
+	return &PanicError{Value: r, Stack: debug.Stack()}
+}
+
+// SetPanicHandler sets the function called with every panic recovered from
+// a job. It runs on the worker before its slot is released.
+func (wp *WorkerPool) SetPanicHandler(handler func(err *PanicError)) {
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+	wp.onPanic = handler
+}
+
+func (wp *WorkerPool) panicked(err *PanicError) {
+	wp.lock.Lock()
+	wp.failed = wp.failed + 1
+	wp.panics = wp.panics + 1
+	handler := wp.onPanic
+	wp.lock.Unlock()
+	if handler != nil {
+		handler(err)
+	}
+}
+
+func (wp *WorkerPool) taskFailed() {
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+	wp.failed = wp.failed + 1
+}
@@ -0,0 +1,56 @@
+package hw2workerpool
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestJobPanic(t *testing.T) {
+	jobs := make(chan func(chan interface{}))
+	results := make(chan interface{}, 1)
+	wp := StartWorkerPool(1, jobs, results)
+	defer wp.Finish()
+	errs := make(chan *PanicError, 2)
+	wp.SetPanicHandler(func(err *PanicError) {
+		errs <- err
+	})
+
+	jobs <- func(chan interface{}) {
+		panic("raw job")
+	}
+	err := <-errs
+	if err.Value != "raw job" || !strings.Contains(string(err.Stack), "TestJobPanic") {
+		t.Errorf("panic should be reported with the stack of the job: %v", err)
+	}
+	if err.Error() != "panic: raw job" {
+		t.Errorf("error message should not carry the stack: %q", err.Error())
+	}
+
+	f := wp.Submit(func() (interface{}, error) {
+		var m map[string]int
+		m["boom"]++
+		return nil, nil
+	})
+	_, ferr := f.Wait(context.Background())
+	var perr *PanicError
+	if !errors.As(ferr, &perr) || perr != <-errs {
+		t.Errorf("task panic should resolve its future and be reported: %v", ferr)
+	}
+
+	jobs <- func(results chan interface{}) {
+		results <- "alive"
+	}
+	if res := <-results; res != "alive" {
+		t.Errorf("worker should survive panics: %v", res)
+	}
+	wp.Submit(func() (interface{}, error) {
+		return nil, errors.New("failed")
+	}).Wait(context.Background())
+
+	wp.Shutdown(context.Background())
+	if stats := wp.Stats(); stats != (PoolStats{Completed: 4, Failed: 3, Panics: 2}) {
+		t.Errorf("wrong stats: %+v", stats)
+	}
+}
@@ -0,0 +1,56 @@
+package hw2workerpool
+
+import "context"
+
+// Pool is a typed worker pool running fn for every submitted value.
+// fn gets the task context, done when the task is cancelled or its
+// deadline passes. Sizing methods come from the embedded WorkerPool.
+type Pool[T, R any] struct {
+	*WorkerPool
+	fn func(context.Context, T) (R, error)
+}
+
+func NewPool[T, R any](count int, fn func(context.Context, T) (R, error)) *Pool[T, R] {
+	return &Pool[T, R]{
+		WorkerPool: StartWorkerPool(count, nil, nil),
+		fn:         fn,
+	}
+}
+
+func (p *Pool[T, R]) Submit(val T) *TypedFuture[R] {
+	return p.SubmitWith(JobOptions{}, val)
+}
+
+func (p *Pool[T, R]) SubmitWith(opts JobOptions, val T) *TypedFuture[R] {
+	return &TypedFuture[R]{p.WorkerPool.SubmitWith(opts, func(ctx context.Context) (interface{}, error) {
+		return p.fn(ctx, val)
+	})}
+}
+
+// TypedFuture is a Future with a typed result.
+type TypedFuture[R any] struct {
+	future *Future
+}
+
+func (f *TypedFuture[R]) Done() <-chan struct{} {
+	return f.future.Done()
+}
+
+func (f *TypedFuture[R]) Wait(ctx context.Context) (R, error) {
+	res, err := f.future.Wait(ctx)
+	val, _ := res.(R)
+	return val, err
+}
+
+func (f *TypedFuture[R]) Cancel() bool {
+	return f.future.Cancel()
+}
+
+func (f *TypedFuture[R]) Result() R {
+	val, _ := f.future.Result().(R)
+	return val
+}
+
+func (f *TypedFuture[R]) Err() error {
+	return f.future.Err()
+}
@@ -0,0 +1,88 @@
+package hw2workerpool
+
+import (
+	"container/heap"
+	"time"
+)
+
+// taskQueue is a heap of submitted tasks: higher rank goes first, then earlier
+// deadline, then earlier submission. Rank is the priority lowered by the number
+// of whole aging intervals from the pool start to the submission, so waiting
+// for an interval is worth one priority level, tasks submitted within the same
+// interval compare by priority and deadline, and the order of queued tasks
+// does not change with time.
+type taskQueue []*task
+
+func (q taskQueue) Len() int {
+	return len(q)
+}
+
+func (q taskQueue) Less(i, j int) bool {
+	a, b := q[i], q[j]
+	if a.rank != b.rank {
+		return a.rank > b.rank
+	}
+	if !a.deadline.Equal(b.deadline) {
+		if a.deadline.IsZero() || b.deadline.IsZero() {
+			return b.deadline.IsZero()
+		}
+		return a.deadline.Before(b.deadline)
+	}
+	return a.seq < b.seq
+}
+
+func (q taskQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *taskQueue) Push(x interface{}) {
+	t := x.(*task)
+	t.index = len(*q)
+	*q = append(*q, t)
+}
+
+func (q *taskQueue) Pop() interface{} {
+	old := *q
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	t.index = -1
+	*q = old[:len(old)-1]
+	return t
+}
+
+func (wp *WorkerPool) rank(t *task) int64 {
+	if wp.aging <= 0 {
+		return int64(t.priority)
+	}
+	return int64(t.priority) - int64(t.enqueued.Sub(wp.started)/wp.aging)
+}
+
+// SetAging makes queued tasks gain one priority level per interval of waiting,
+// so bulk work is not starved by a stream of urgent tasks. 0 disables aging.
+func (wp *WorkerPool) SetAging(interval time.Duration) {
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+	wp.aging = interval
+	for _, t := range wp.queue {
+		t.rank = wp.rank(t)
+	}
+	heap.Init(&wp.queue)
+}
+
+// enqueue puts a new task into the queue, or behind the task of the same key.
+func (wp *WorkerPool) enqueue(t *task) {
+	wp.seq = wp.seq + 1
+	t.seq = wp.seq
+	t.enqueued = time.Now()
+	if t.key != "" && wp.keyBusy(t) {
+		return
+	}
+	wp.push(t)
+}
+
+func (wp *WorkerPool) push(t *task) {
+	t.rank = wp.rank(t)
+	heap.Push(&wp.queue, t)
+}
@@ -0,0 +1,117 @@
+package hw2workerpool
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// orderRecorder runs tasks on a busy single worker pool and records their order.
+type orderRecorder struct {
+	mu    sync.Mutex
+	order []string
+}
+
+func (r *orderRecorder) task(name string) func(context.Context) (interface{}, error) {
+	return func(context.Context) (interface{}, error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.order = append(r.order, name)
+		return nil, nil
+	}
+}
+
+func blockPool(wp *WorkerPool) chan struct{} {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	wp.Submit(func() (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+	return release
+}
+
+func TestPriorityQueue(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	release := blockPool(wp)
+	rec := &orderRecorder{}
+	now := time.Now()
+	wp.SubmitWith(JobOptions{}, rec.task("bulk1"))
+	wp.SubmitWith(JobOptions{Priority: 5}, rec.task("urgent"))
+	wp.SubmitWith(JobOptions{Priority: 1, Deadline: now.Add(time.Hour)}, rec.task("late"))
+	wp.SubmitWith(JobOptions{Priority: 1, Deadline: now.Add(time.Minute)}, rec.task("soon"))
+	wp.SubmitWith(JobOptions{Priority: 1}, rec.task("whenever"))
+	wp.SubmitWith(JobOptions{}, rec.task("bulk2"))
+	close(release)
+	wp.Shutdown(context.Background(), DrainQueue)
+
+	expected := []string{"urgent", "soon", "late", "whenever", "bulk1", "bulk2"}
+	if len(rec.order) != len(expected) {
+		t.Fatalf("wrong order: %v", rec.order)
+	}
+	for i := range expected {
+		if rec.order[i] != expected[i] {
+			t.Fatalf("wrong order\nGot: %v\nExpected: %v", rec.order, expected)
+		}
+	}
+}
+
+func TestPriorityAging(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	wp.SetAging(10 * time.Millisecond)
+	release := blockPool(wp)
+	rec := &orderRecorder{}
+	wp.SubmitWith(JobOptions{}, rec.task("old"))
+	time.Sleep(50 * time.Millisecond)
+	wp.SubmitWith(JobOptions{Priority: 2}, rec.task("new"))
+	close(release)
+	wp.Shutdown(context.Background(), DrainQueue)
+
+	if len(rec.order) != 2 || rec.order[0] != "old" {
+		t.Errorf("aged task should overtake newer urgent one: %v", rec.order)
+	}
+}
+
+func TestPriorityCancel(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	release := blockPool(wp)
+	rec := &orderRecorder{}
+	futures := make([]*Future, 4)
+	for i, name := range []string{"a", "b", "c", "d"} {
+		futures[i] = wp.SubmitWith(JobOptions{Priority: i}, rec.task(name))
+	}
+	futures[2].Cancel()
+	futures[0].Cancel()
+	close(release)
+	wp.Shutdown(context.Background(), DrainQueue)
+
+	if len(rec.order) != 2 || rec.order[0] != "d" || rec.order[1] != "b" {
+		t.Errorf("cancelled tasks should leave the queue: %v", rec.order)
+	}
+}
+
+func TestPriorityAgingDeadline(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	wp.SetAging(time.Hour)
+	release := blockPool(wp)
+	rec := &orderRecorder{}
+	now := time.Now()
+	wp.SubmitWith(JobOptions{Deadline: now.Add(time.Hour)}, rec.task("hour"))
+	wp.SubmitWith(JobOptions{Deadline: now.Add(time.Minute)}, rec.task("minute"))
+	wp.SubmitWith(JobOptions{Priority: 1}, rec.task("urgent"))
+	close(release)
+	wp.Shutdown(context.Background(), DrainQueue)
+
+	expected := []string{"urgent", "minute", "hour"}
+	if len(rec.order) != len(expected) {
+		t.Fatalf("wrong order: %v", rec.order)
+	}
+	for i := range expected {
+		if rec.order[i] != expected[i] {
+			t.Fatalf("deadline should break ties with aging on\nGot: %v\nExpected: %v", rec.order, expected)
+		}
+	}
+}
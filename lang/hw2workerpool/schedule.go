@@ -0,0 +1,180 @@
+package hw2workerpool
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Trigger tells when a scheduled job runs next, zero time ends the schedule.
+type Trigger interface {
+	Next(after time.Time) time.Time
+}
+
+type delayTrigger struct {
+	at    time.Time
+	mu    sync.Mutex
+	fired bool
+}
+
+// Delay fires once, d from now. The first Next returns the delay time
+// even if it has passed meanwhile, so a trigger serves a single schedule.
+func Delay(d time.Duration) Trigger {
+	return &delayTrigger{at: time.Now().Add(d)}
+}
+
+func (t *delayTrigger) Next(after time.Time) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.fired {
+		return time.Time{}
+	}
+	t.fired = true
+	return t.at
+}
+
+type rateTrigger struct {
+	start    time.Time
+	interval time.Duration
+}
+
+// Rate fires every interval from now. Runs missed while the schedule
+// was behind are not caught up.
+func Rate(interval time.Duration) Trigger {
+	return rateTrigger{time.Now(), interval}
+}
+
+func (t rateTrigger) Next(after time.Time) time.Time {
+	if t.interval <= 0 {
+		return time.Time{}
+	}
+	if after.Before(t.start) {
+		return t.start.Add(t.interval)
+	}
+	n := after.Sub(t.start)/t.interval + 1
+	return t.start.Add(n * t.interval)
+}
+
+type ScheduleOptions struct {
+	// SkipOverlap skips a run while the previous one is queued or running.
+	SkipOverlap bool
+	// Job options are used for every run.
+	Job JobOptions
+}
+
+// Schedule is a handle of a scheduled job.
+type Schedule struct {
+	mu      sync.Mutex
+	last    *Future
+	runs    int
+	skipped int
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// Schedule submits f to the pool every time the trigger fires, until the
+// schedule is cancelled, the trigger ends or the pool stops accepting jobs.
+func (wp *WorkerPool) Schedule(tr Trigger, opts ScheduleOptions, f func(ctx context.Context) (interface{}, error)) *Schedule {
+	s := &Schedule{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go s.loop(wp, tr, opts, f)
+	return s
+}
+
+// After runs f on the pool once, d from now.
+func (wp *WorkerPool) After(d time.Duration, f func(ctx context.Context) (interface{}, error)) *Schedule {
+	return wp.Schedule(Delay(d), ScheduleOptions{}, f)
+}
+
+// Every runs f on the pool every interval.
+func (wp *WorkerPool) Every(interval time.Duration, opts ScheduleOptions, f func(ctx context.Context) (interface{}, error)) *Schedule {
+	return wp.Schedule(Rate(interval), opts, f)
+}
+
+// Cron runs f on the pool at times matching a cron expression, see ParseCron.
+func (wp *WorkerPool) Cron(expr string, opts ScheduleOptions, f func(ctx context.Context) (interface{}, error)) (*Schedule, error) {
+	tr, err := ParseCron(expr)
+	if err != nil {
+		return nil, err
+	}
+	return wp.Schedule(tr, opts, f), nil
+}
+
+func (s *Schedule) loop(wp *WorkerPool, tr Trigger, opts ScheduleOptions, f func(ctx context.Context) (interface{}, error)) {
+	defer close(s.done)
+	for next := tr.Next(time.Now()); !next.IsZero(); next = tr.Next(time.Now()) {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-wp.ctx.Done():
+			timer.Stop()
+			return
+		}
+		if !s.fire(wp, opts, f) {
+			return
+		}
+	}
+}
+
+// fire submits a run, the lock is not held while submitting since Submit
+// may block or run the job in place. Only the loop calls fire.
+func (s *Schedule) fire(wp *WorkerPool, opts ScheduleOptions, f func(ctx context.Context) (interface{}, error)) bool {
+	s.mu.Lock()
+	last := s.last
+	s.mu.Unlock()
+	if opts.SkipOverlap && last != nil {
+		select {
+		case <-last.Done():
+		default:
+			s.mu.Lock()
+			s.skipped = s.skipped + 1
+			s.mu.Unlock()
+			return true
+		}
+	}
+	future := wp.SubmitWith(opts.Job, f)
+	s.mu.Lock()
+	s.last = future
+	s.runs = s.runs + 1
+	s.mu.Unlock()
+	return future.Err() != ErrPoolFinished
+}
+
+// Cancel stops further runs, a run already submitted is left alone.
+func (s *Schedule) Cancel() {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+// Done is closed when the schedule has ended.
+func (s *Schedule) Done() <-chan struct{} {
+	return s.done
+}
+
+// Last returns the future of the latest run, nil before the first one.
+func (s *Schedule) Last() *Future {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// Runs counts submitted runs, Skipped counts runs skipped for overlap.
+func (s *Schedule) Runs() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.runs
+}
+
+func (s *Schedule) Skipped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.skipped
+}
@@ -0,0 +1,171 @@
+package hw2workerpool
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAfter(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	defer wp.Finish()
+	start := time.Now()
+	s := wp.After(20*time.Millisecond, func(context.Context) (interface{}, error) {
+		return time.Since(start), nil
+	})
+	<-s.Done()
+	res, err := s.Last().Wait(context.Background())
+	if err != nil || res.(time.Duration) < 20*time.Millisecond {
+		t.Errorf("delayed job should run after the delay: %v, %v", res, err)
+	}
+	if s.Runs() != 1 {
+		t.Errorf("delayed job should run once: %v", s.Runs())
+	}
+
+	cancelled := wp.After(time.Hour, func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	cancelled.Cancel()
+	if cancelled.Runs() != 0 || cancelled.Last() != nil {
+		t.Errorf("cancelled schedule should not run")
+	}
+}
+
+func TestAfterPassedDelay(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	defer wp.Finish()
+	for _, d := range []time.Duration{0, time.Microsecond, -time.Second} {
+		s := wp.After(d, func(context.Context) (interface{}, error) {
+			return nil, nil
+		})
+		<-s.Done()
+		if s.Runs() != 1 {
+			t.Errorf("job delayed by %v should run once: %v", d, s.Runs())
+		}
+	}
+}
+
+func TestEvery(t *testing.T) {
+	wp := StartWorkerPool(2, nil, nil)
+	defer wp.Finish()
+	var calls int32
+	s := wp.Every(10*time.Millisecond, ScheduleOptions{}, func(context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, nil
+	})
+	time.Sleep(55 * time.Millisecond)
+	s.Cancel()
+	n := atomic.LoadInt32(&calls)
+	if n < 3 || n > 6 {
+		t.Errorf("job should run at fixed rate, runs: %v", n)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt32(&calls) != n {
+		t.Errorf("cancelled schedule should not run")
+	}
+}
+
+func TestEverySkipOverlap(t *testing.T) {
+	wp := StartWorkerPool(2, nil, nil)
+	defer wp.Finish()
+	var running, overlapped int32
+	s := wp.Every(5*time.Millisecond, ScheduleOptions{SkipOverlap: true}, func(context.Context) (interface{}, error) {
+		if atomic.AddInt32(&running, 1) > 1 {
+			atomic.StoreInt32(&overlapped, 1)
+		}
+		time.Sleep(22 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil, nil
+	})
+	time.Sleep(100 * time.Millisecond)
+	s.Cancel()
+	if overlapped != 0 {
+		t.Errorf("runs should not overlap")
+	}
+	if s.Skipped() == 0 || s.Runs() == 0 {
+		t.Errorf("overlapping runs should be skipped: runs %v, skipped %v", s.Runs(), s.Skipped())
+	}
+}
+
+func TestScheduleCallerRuns(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	defer wp.Finish()
+	release := blockPool(wp)
+	defer close(release)
+	wp.SetQueueLimit(1, OverflowCallerRuns)
+	wp.Submit(sleepTask(0))
+
+	inside := make(chan struct{})
+	proceed := make(chan struct{})
+	s := wp.After(time.Millisecond, func(context.Context) (interface{}, error) {
+		close(inside)
+		<-proceed
+		return nil, nil
+	})
+	<-inside
+	done := make(chan int)
+	go func() {
+		done <- s.Runs()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("schedule stats should not wait for a run in place")
+	}
+	close(proceed)
+	<-s.Done()
+}
+
+func TestScheduleStopsWithPool(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	s := wp.Every(5*time.Millisecond, ScheduleOptions{}, func(context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	wp.Shutdown(context.Background())
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Errorf("schedule should end when the pool stops accepting jobs")
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	base := time.Date(2024, time.February, 27, 10, 17, 30, 0, time.UTC)
+	cases := []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2024, 2, 27, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 2, 27, 10, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2024, 2, 27, 13, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2024, 2, 28, 2, 30, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1 * 1,3", time.Date(2024, 2, 28, 12, 0, 0, 0, time.UTC)},
+		{"0 0 */2 * 1", time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, c := range cases {
+		tr, err := ParseCron(c.expr)
+		if err != nil {
+			t.Errorf("%q: %v", c.expr, err)
+			continue
+		}
+		if next := tr.Next(base); !next.Equal(c.next) {
+			t.Errorf("%q: next run %v, expected %v", c.expr, next, c.next)
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("%q should not parse", expr)
+		}
+	}
+	wp := StartWorkerPool(1, nil, nil)
+	defer wp.Finish()
+	if _, err := wp.Cron("bad", ScheduleOptions{}, nil); err == nil {
+		t.Errorf("Cron should report a bad expression")
+	}
+}
@@ -0,0 +1,143 @@
+package hw2workerpool
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func sleepTask(d time.Duration) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		time.Sleep(d)
+		return nil, nil
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	wp := StartWorkerPool(2, nil, nil)
+	started := make(chan struct{}, 5)
+	release := make(chan struct{})
+	futures := make([]*Future, 5)
+	for i := range futures {
+		futures[i] = wp.Submit(func() (interface{}, error) {
+			started <- struct{}{}
+			<-release
+			return nil, nil
+		})
+	}
+	<-started
+	<-started
+
+	var report ShutdownReport
+	var err error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		report, err = wp.Shutdown(context.Background())
+	}()
+	// queued jobs are abandoned before running ones are waited for
+	if _, err := futures[4].Wait(context.Background()); err != ErrPoolFinished {
+		t.Errorf("queued job should be abandoned, got %v", err)
+	}
+	close(release)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (ShutdownReport{Completed: 2, Abandoned: 3}) {
+		t.Errorf("running jobs should complete and queued ones be abandoned: %+v", report)
+	}
+	if wp.ActiveCount() != 0 {
+		t.Errorf("no jobs should run after shutdown: %v", wp.ActiveCount())
+	}
+	if _, err := wp.Submit(sleepTask(0)).Wait(context.Background()); err != ErrPoolFinished {
+		t.Errorf("shut down pool should not accept jobs, got %v", err)
+	}
+}
+
+func TestShutdownDrainQueue(t *testing.T) {
+	jobs := make(chan func(chan interface{}), 1)
+	wp := StartWorkerPool(2, jobs, nil)
+	futures := make([]*Future, 5)
+	for i := range futures {
+		futures[i] = wp.Submit(sleepTask(10 * time.Millisecond))
+	}
+
+	report, err := wp.Shutdown(context.Background(), DrainQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (ShutdownReport{Completed: 5}) {
+		t.Errorf("queued tasks should be drained: %+v", report)
+	}
+	for _, f := range futures {
+		if _, err := f.Wait(context.Background()); err != nil {
+			t.Errorf("drained task failed: %v", err)
+		}
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	wp := StartWorkerPool(1, nil, nil)
+	started := make(chan struct{})
+	slow := wp.SubmitContext(func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	wp.Submit(sleepTask(0))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	report, err := wp.Shutdown(ctx, DrainQueue, CancelInFlight)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected deadline error, got %v", err)
+	}
+	if report != (ShutdownReport{Abandoned: 1, Running: 1}) {
+		t.Errorf("wrong report of expired shutdown: %+v", report)
+	}
+	if _, err := slow.Wait(context.Background()); err != context.Canceled {
+		t.Errorf("in-flight job should be cancelled, got %v", err)
+	}
+}
+
+func TestShutdownRawJobs(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		jobs := make(chan func(chan interface{}))
+		wp := StartWorkerPool(1, jobs, nil)
+		var started int32
+		stop := make(chan struct{})
+		sent := make(chan struct{})
+		go func() {
+			defer close(sent)
+			for {
+				select {
+				case jobs <- func(chan interface{}) {
+					atomic.AddInt32(&started, 1)
+				}:
+				case <-stop:
+					return
+				}
+			}
+		}()
+		time.Sleep(time.Millisecond)
+
+		report, err := wp.Shutdown(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		after := atomic.LoadInt32(&started)
+		close(stop)
+		<-sent
+		time.Sleep(time.Millisecond)
+		if atomic.LoadInt32(&started) != after {
+			t.Fatalf("raw job started after shutdown returned")
+		}
+		snap := wp.Snapshot()
+		if snap.Completed+report.Abandoned != snap.Submitted {
+			t.Fatalf("every taken job should be completed or abandoned: %+v, %+v", snap, report)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package hw2workerpool
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TimeBuckets are upper bounds of the wait and run time histograms.
+var TimeBuckets = []time.Duration{
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	30 * time.Second,
+}
+
+// maxResizeEvents is how many recent resizes are kept for snapshots.
+const maxResizeEvents = 64
+
+// timeHistogram counts durations in cumulative buckets, as Prometheus
+// exposes them: a duration is counted by every bucket it fits in.
+type timeHistogram struct {
+	buckets []Bucket
+	count   int64
+	sum     time.Duration
+}
+
+func newTimeHistogram(bounds []time.Duration) timeHistogram {
+	h := timeHistogram{buckets: make([]Bucket, len(bounds))}
+	for i, bound := range bounds {
+		h.buckets[i].UpperBound = bound
+	}
+	return h
+}
+
+func (h *timeHistogram) observe(d time.Duration) {
+	first := sort.Search(len(h.buckets), func(i int) bool {
+		return d <= h.buckets[i].UpperBound
+	})
+	for i := first; i < len(h.buckets); i++ {
+		h.buckets[i].Count++
+	}
+	h.count++
+	h.sum += d
+}
+
+func (h *timeHistogram) snapshot() TimeHistogram {
+	return TimeHistogram{
+		Buckets: append([]Bucket(nil), h.buckets...),
+		Count:   h.count,
+		Sum:     h.sum,
+	}
+}
+
+// Bucket counts durations up to UpperBound.
+type Bucket struct {
+	UpperBound time.Duration
+	Count      int64
+}
+
+// TimeHistogram is a snapshot of a duration histogram, Count includes
+// durations above the last bucket.
+type TimeHistogram struct {
+	Buckets []Bucket
+	Count   int64
+	Sum     time.Duration
+}
+
+func (h TimeHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+type ResizeEvent struct {
+	Time time.Time
+	From int
+	To   int
+}
+
+type PoolStats struct {
+	Completed int
+	// Failed counts jobs that panicked and tasks that returned an error.
+	Failed int
+	Panics int
+}
+
+func (wp *WorkerPool) Stats() PoolStats {
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+	return PoolStats{
+		Completed: wp.completed,
+		Failed:    wp.failed,
+		Panics:    wp.panics,
+	}
+}
+
+type StatsSnapshot struct {
+	PoolStats
+	// Submitted counts accepted tasks and jobs taken from the jobs channel.
+	Submitted int
+	Queued    int
+	Active    int
+	Size      int
+	// WaitTime is the queue wait of jobs, RunTime is their run time.
+	WaitTime TimeHistogram
+	RunTime  TimeHistogram
+	// ResizeCount counts all resizes, Resizes keeps the recent ones.
+	ResizeCount int
+	Resizes     []ResizeEvent
+	// Rejected, Dropped and CallerRuns count tasks that overflowed the queue.
+	Rejected   int
+	Dropped    int
+	CallerRuns int
+}
+
+func (wp *WorkerPool) Snapshot() StatsSnapshot {
+	stats := wp.Stats()
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+	return StatsSnapshot{
+		PoolStats:   stats,
+		Submitted:   wp.submitted,
+		Queued:      wp.queuedLocked() + len(wp.jobs),
+		Active:      wp.counter,
+		Size:        wp.size,
+		WaitTime:    wp.waitTime.snapshot(),
+		RunTime:     wp.runTime.snapshot(),
+		ResizeCount: wp.resizeCount,
+		Resizes:     append([]ResizeEvent(nil), wp.resizes...),
+		Rejected:    wp.rejected,
+		Dropped:     wp.dropped,
+		CallerRuns:  wp.callerRuns,
+	}
+}
+
+// resized records a resize, the lock should be held.
+func (wp *WorkerPool) resized(from int) {
+	wp.resizeCount = wp.resizeCount + 1
+	wp.resizes = append(wp.resizes, ResizeEvent{Time: time.Now(), From: from, To: wp.size})
+	if len(wp.resizes) > maxResizeEvents {
+		wp.resizes = wp.resizes[len(wp.resizes)-maxResizeEvents:]
+	}
+}
+
+// observeRun records the run time of a job started at start.
+func (wp *WorkerPool) observeRun(start time.Time) {
+	d := time.Since(start)
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+	wp.runTime.observe(d)
+}
+
+func (s StatsSnapshot) WritePrometheus(w io.Writer) error {
+	b := &strings.Builder{}
+	metric := func(name, kind, help string, value int) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+	}
+	metric("workerpool_jobs_submitted_total", "counter", "Jobs accepted by the pool.", s.Submitted)
+	metric("workerpool_jobs_completed_total", "counter", "Jobs that have returned.", s.Completed)
+	metric("workerpool_jobs_failed_total", "counter", "Jobs that panicked or returned an error.", s.Failed)
+	metric("workerpool_jobs_panicked_total", "counter", "Jobs that panicked.", s.Panics)
+	metric("workerpool_jobs_queued", "gauge", "Jobs waiting for a worker.", s.Queued)
+	metric("workerpool_workers_active", "gauge", "Workers running a job.", s.Active)
+	metric("workerpool_workers", "gauge", "Pool size.", s.Size)
+	metric("workerpool_resizes_total", "counter", "Pool resizes.", s.ResizeCount)
+	metric("workerpool_jobs_rejected_total", "counter", "Tasks rejected by the full queue.", s.Rejected)
+	metric("workerpool_jobs_dropped_total", "counter", "Queued tasks dropped for newer ones.", s.Dropped)
+	metric("workerpool_jobs_caller_runs_total", "counter", "Tasks run by the submitter as the queue was full.", s.CallerRuns)
+
+	histogram := func(name, help string, h TimeHistogram) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+		for _, bucket := range h.Buckets {
+			fmt.Fprintf(b, "%s_bucket{le=\"%g\"} %d\n", name, bucket.UpperBound.Seconds(), bucket.Count)
+		}
+		fmt.Fprintf(b, "%s_bucket{le=\"+Inf\"} %d\n", name, h.Count)
+		fmt.Fprintf(b, "%s_sum %g\n", name, h.Sum.Seconds())
+		fmt.Fprintf(b, "%s_count %d\n", name, h.Count)
+	}
+	histogram("workerpool_job_wait_seconds", "Job queue wait.", s.WaitTime)
+	histogram("workerpool_job_run_seconds", "Job run time.", s.RunTime)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// StatsHandler serves pool statistics in Prometheus text format.
+func (wp *WorkerPool) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		wp.Snapshot().WritePrometheus(w)
+	})
+}
@@ -0,0 +1,82 @@
+package hw2workerpool
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	jobs := make(chan func(chan interface{}), 1)
+	wp := StartWorkerPool(1, jobs, nil)
+	release := blockPool(wp)
+	wp.Submit(func() (interface{}, error) {
+		return nil, errors.New("failed")
+	})
+	jobs <- func(chan interface{}) {}
+
+	snap := wp.Snapshot()
+	if snap.Submitted != 2 || snap.Queued != 2 || snap.Active != 1 || snap.Size != 1 {
+		t.Errorf("wrong snapshot of busy pool: %+v", snap)
+	}
+	wp.AddWorkers(2)
+	wp.DecWorkers(1)
+	close(release)
+	for len(jobs) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	wp.Shutdown(context.Background(), DrainQueue)
+
+	snap = wp.Snapshot()
+	if snap.PoolStats != (PoolStats{Completed: 3, Failed: 1}) {
+		t.Errorf("wrong counters: %+v", snap.PoolStats)
+	}
+	if snap.RunTime.Count != 3 || snap.WaitTime.Count != 3 {
+		t.Errorf("every job run and wait should be observed: %+v, %+v", snap.RunTime, snap.WaitTime)
+	}
+	if last := snap.RunTime.Buckets[len(TimeBuckets)-1]; last.UpperBound != 30*time.Second || last.Count != 3 {
+		t.Errorf("buckets should be cumulative: %+v", snap.RunTime.Buckets)
+	}
+	if snap.ResizeCount != 2 || len(snap.Resizes) != 2 ||
+		snap.Resizes[0].From != 1 || snap.Resizes[0].To != 3 || snap.Resizes[1].To != 2 {
+		t.Errorf("wrong resize events: %+v", snap.Resizes)
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	wp := StartWorkerPool(2, nil, nil)
+	wp.Submit(func() (interface{}, error) {
+		time.Sleep(20 * time.Millisecond)
+		return nil, nil
+	})
+	wp.Shutdown(context.Background(), DrainQueue)
+
+	server := httptest.NewServer(wp.StatsHandler())
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("wrong content type: %v", resp.Header.Get("Content-Type"))
+	}
+	for _, line := range []string{
+		"# TYPE workerpool_jobs_submitted_total counter",
+		"workerpool_jobs_completed_total 1",
+		"workerpool_workers 2",
+		`workerpool_job_run_seconds_bucket{le="0.01"} 0`,
+		`workerpool_job_run_seconds_bucket{le="0.1"} 1`,
+		`workerpool_job_run_seconds_bucket{le="+Inf"} 1`,
+		"workerpool_job_wait_seconds_count 1",
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("metrics should contain %q:\n%s", line, body)
+		}
+	}
+}